@@ -1,3 +1,4 @@
+-- The tables are made by the migrations of the server, which runs as this user.
 CREATE USER IF NOT EXISTS 'app'@'%' IDENTIFIED BY 'app';
-GRANT SELECT,INSERT,UPDATE,DELETE ON todo.todo_list TO 'app'@'%';
-GRANT SELECT,INSERT,UPDATE,DELETE ON auth.users TO 'app'@'%';
\ No newline at end of file
+GRANT SELECT,INSERT,UPDATE,DELETE,CREATE,DROP,ALTER,INDEX,REFERENCES ON todo.* TO 'app'@'%';
+GRANT SELECT,INSERT,UPDATE,DELETE,CREATE,DROP,ALTER,INDEX,REFERENCES ON auth.* TO 'app'@'%';
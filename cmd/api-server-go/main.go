@@ -1,52 +1,250 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
+	"github.com/Soya-Onishi/api-server-go/internal/config"
 	"github.com/Soya-Onishi/api-server-go/internal/controller"
+	"github.com/Soya-Onishi/api-server-go/internal/logging"
+	"github.com/Soya-Onishi/api-server-go/internal/metrics"
 	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/Soya-Onishi/api-server-go/internal/repository/memory"
 	"github.com/gin-gonic/gin"
 )
 
-type DBProfile struct {
-	user     string
-	password string
-	url      string
-	dbname   string
+// openDB connects to the database of the storage.
+// source is the database file of sqlite or the connection string of mysql and postgres.
+func openDB(kind string, source string) (*sql.DB, error) {
+	switch kind {
+	case "mysql":
+		return sql.Open("mysql", source)
+	case "sqlite":
+		return repository.OpenSQLite(source)
+	case "postgres":
+		return repository.OpenPostgres(source)
+	default:
+		return nil, fmt.Errorf("unknown storage: %v", kind)
+	}
 }
 
-var profile = DBProfile{
-	user:     "app",
-	password: "app",
-	url:      "172.25.172.3:3306",
-	dbname:   "todo",
+// openRepository connects to the storage selected by the configuration,
+// applying the migrations which are not applied yet when migrate is true.
+// The database is returned to check its health, and is nil for memory.
+// Todos in memory are lost when the server stops.
+func openRepository(kind string, source string, migrate bool) (repository.TodoListManipulation, *sql.DB, error) {
+	if kind == "memory" {
+		return memory.NewRepository(), nil, nil
+	}
+
+	db, err := openDB(kind, source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if migrate {
+		migrator, err := repository.NewMigrator(db, kind)
+		if err == nil {
+			err = migrator.Up(context.Background())
+		}
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+	}
+
+	switch kind {
+	case "sqlite":
+		return repository.NewSQLiteRepository(db), db, nil
+	case "postgres":
+		return repository.NewPostgresRepository(db), db, nil
+	default:
+		return repository.NewRepository(db), db, nil
+	}
 }
 
-func setupServer() *controller.Router {
-	var db *sql.DB
-	var err error
-
-	dsn := fmt.Sprintf(
-		"%v:%v@(%v)/%v",
-		profile.user,
-		profile.password,
-		profile.url,
-		profile.dbname,
-	)
-	db, err = sql.Open("mysql", dsn)
+// runMigrate runs the migrate subcommand, which is one of
+//
+//	migrate up
+//	migrate down [steps]
+//	migrate status
+func runMigrate(kind string, source string, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [steps] | status")
+	}
+
+	db, err := openDB(kind, source)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := repository.NewMigrator(db, kind)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		if err := migrator.Up(ctx); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive number: %v", args[1])
+			}
+		}
+
+		if err := migrator.Down(ctx, steps); err != nil {
+			return err
+		}
+	case "status":
+		// Only the status below is printed.
+	default:
+		return fmt.Errorf("unknown migrate command: %v", args[0])
 	}
 
-	engine := gin.Default()
-	repo := repository.NewRepository(db)
+	statuses, err := migrator.Status(ctx)
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied at " + status.AppliedAt.Format(time.RFC3339)
+		}
 
-	return controller.NewRouter(engine, repo)
+		fmt.Fprintf(out, "%04d_%v\t%v\n", status.Version, status.Name, state)
+	}
+
+	return err
+}
+
+// setupServer builds the router and the purger on the repository,
+// which is returned to be closed after the server stops.
+func setupServer(cfg *config.Config, logger *logging.Logger) (*controller.Router, *repository.Purger, repository.TodoListManipulation, error) {
+	repo, db, err := openRepository(cfg.Storage, cfg.Source(), cfg.Migrate)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if repo, ok := repo.(*repository.Repository); ok {
+		repo.SetTimeouts(cfg.Timeouts)
+		repo.SetLogger(logger)
+	}
+
+	if cfg.Log.Level == "debug" {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	// Requests are logged and recovered by the router with their IDs.
+	engine := gin.New()
+
+	registry := metrics.NewRegistry()
+	if db != nil {
+		metrics.RegisterDBStats(registry, db)
+	}
+	latency := registry.NewHistogramVec("repository_operation_duration_seconds", "Latency of repository operations.", metrics.DefaultBuckets, "operation", "result")
+	observed := repository.Instrument(repo, func(operation string, duration time.Duration, err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		latency.Observe(duration.Seconds(), operation, result)
+	})
+
+	purger := repository.NewPurger(observed, cfg.Trash.Retention, cfg.API.IdempotencyWindow, cfg.Trash.PurgeInterval)
+	purger.SetLogger(logger)
+
+	router := controller.NewRouter(engine, observed)
+	router.SetLogger(logger)
+	router.SetMetrics(registry)
+	router.SetStrictPrecondition(cfg.API.StrictPrecondition)
+	router.SetIdempotencyWindow(cfg.API.IdempotencyWindow)
+	router.SetMaxBatchSize(cfg.API.MaxBatchSize)
+	router.SetCookieOptions(controller.CookieOptions{
+		MaxAge: cfg.Cookie.MaxAge,
+		Path:   cfg.Cookie.Path,
+		Domain: cfg.Cookie.Domain,
+		Secure: cfg.Cookie.Secure,
+	})
+
+	router.SetReadinessTimeout(cfg.Server.ReadinessTimeout)
+	router.SetReadinessGrace(cfg.Server.ReadinessGrace)
+	if db != nil {
+		migrator, err := repository.NewMigrator(db, cfg.Storage)
+		if err != nil {
+			db.Close()
+			return nil, nil, nil, err
+		}
+
+		router.AddReadinessCheck("database", db.PingContext)
+		router.AddReadinessCheck("migrations", migrator.Verify)
+	}
+
+	return router, purger, repo, nil
 }
 
 func main() {
-	setupServer().Run()
+	logger := logging.Default()
+
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	logger = logging.New(os.Stderr, cfg.LogLevel())
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(cfg.Storage, cfg.Source(), args[1:], os.Stdout); err != nil {
+			logger.Error("migrate failed", "error", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// A second signal kills the server without waiting for the drain.
+		<-ctx.Done()
+		stop()
+	}()
+
+	router, purger, repo, err := setupServer(cfg, logger)
+	if err != nil {
+		logger.Error("failed to set up the server", "storage", cfg.Storage, "error", err)
+		os.Exit(1)
+	}
+	purger.Start()
+
+	logger.Info("listening", "addr", cfg.Server.Addr, "storage", cfg.Storage)
+	err = router.Run(ctx, cfg.Server.Addr, cfg.Server.ShutdownTimeout)
+
+	// The purger is stopped before the database it uses is closed.
+	purger.Stop()
+	if db, ok := repo.(io.Closer); ok {
+		db.Close()
+	}
+
+	if err != nil {
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("server stopped")
 }
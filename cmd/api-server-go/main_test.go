@@ -3,21 +3,26 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/DATA-DOG/go-txdb"
+	"github.com/Soya-Onishi/api-server-go/internal/config"
 	"github.com/Soya-Onishi/api-server-go/internal/controller"
+	"github.com/Soya-Onishi/api-server-go/internal/logging"
 	"github.com/Soya-Onishi/api-server-go/internal/repository"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
 
 func init() {
-	dsn := fmt.Sprintf("%v:%v@(%v)/%v", "app", "app", "db.test", "todo")
+	dsn := fmt.Sprintf("%v:%v@(%v)/%v?parseTime=true", "app", "app", "db.test", "todo")
 	txdb.Register("txdb", "mysql", dsn)
 }
 
@@ -61,3 +66,101 @@ func TestHello(t *testing.T) {
 		t.Fatalf("Expected response body %v, actual %v", mockUserResp, string(respData))
 	}
 }
+
+func TestOpenRepository(t *testing.T) {
+	sources := map[string]string{
+		"sqlite": filepath.Join(t.TempDir(), "todo.db"),
+		"memory": "",
+	}
+
+	for kind, source := range sources {
+		t.Run(kind, func(t *testing.T) {
+			repo, db, err := openRepository(kind, source, true)
+			assert.Nil(t, err)
+			assert.Equal(t, kind == "memory", db == nil)
+
+			ts := httptest.NewServer(controller.NewRouter(gin.New(), repo).GetEngine())
+			defer ts.Close()
+
+			resp, err := http.Post(fmt.Sprintf("%s/todos", ts.URL), "application/json", strings.NewReader(`{"id":"1","name":"boil water"}`))
+			assert.Nil(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			resp, err = http.Get(fmt.Sprintf("%s/todos/1", ts.URL))
+			assert.Nil(t, err)
+			respData, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Contains(t, string(respData), "boil water")
+		})
+	}
+
+	_, _, err := openRepository("oracle", "", true)
+	assert.Error(t, err)
+}
+
+func TestRunMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.db")
+	run := func(args ...string) (string, error) {
+		var out strings.Builder
+		err := runMigrate("sqlite", path, args, &out)
+
+		return out.String(), err
+	}
+
+	out, err := run("status")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "0001_initial_schema\tpending")
+
+	out, err = run("up")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "0001_initial_schema\tapplied at ")
+	assert.Contains(t, out, "0008_idempotency_actors\tapplied at ")
+
+	out, err = run("down", "1")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "0001_initial_schema\tapplied at ")
+	assert.Contains(t, out, "0008_idempotency_actors\tpending")
+
+	_, err = run("down", "zero")
+	assert.Error(t, err)
+	_, err = run("sideways")
+	assert.Error(t, err)
+	_, err = run()
+	assert.Error(t, err)
+}
+
+func TestReadiness(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage = "sqlite"
+	cfg.SQLite.Path = filepath.Join(t.TempDir(), "todo.db")
+	cfg.Migrate = false
+
+	router, _, repo, err := setupServer(cfg, logging.New(ioutil.Discard, logging.LevelInfo))
+	assert.Nil(t, err)
+	defer repo.(io.Closer).Close()
+
+	ts := httptest.NewServer(router.GetEngine())
+	defer ts.Close()
+
+	readyz := func() (int, string) {
+		resp, err := http.Get(fmt.Sprintf("%s/readyz", ts.URL))
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		respData, _ := ioutil.ReadAll(resp.Body)
+
+		return resp.StatusCode, string(respData)
+	}
+
+	status, body := readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Contains(t, body, `"database":{"status":"ok"}`)
+	assert.Contains(t, body, `"migrations":{"status":"failing"`)
+
+	assert.Nil(t, runMigrate("sqlite", cfg.SQLite.Path, []string{"up"}, ioutil.Discard))
+
+	status, body = readyz()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"migrations":{"status":"ok"}`)
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMaxBatchSize = 100
+
+const (
+	batchModeAtomic     = "atomic"
+	batchModeBestEffort = "best_effort"
+)
+
+const (
+	operationCreate = "create"
+	operationUpdate = "update"
+	operationDelete = "delete"
+)
+
+// batchRequest is the body of POST /todos:batch.
+// Each operation is validated on its own so that one invalid operation
+// does not reject the others in best effort mode.
+type batchRequest struct {
+	Mode       string           `json:"mode" binding:"omitempty,oneof=atomic best_effort"`
+	Operations []batchOperation `json:"operations" binding:"required,min=1"`
+}
+
+type batchOperation struct {
+	Op          string  `json:"op" binding:"required,oneof=create update delete"`
+	Id          int     `json:"id" binding:"min=0"`
+	Name        *string `json:"name" binding:"omitempty,notblank,max=128"`
+	Description *string `json:"description" binding:"omitempty,max=1024"`
+	Version     int     `json:"version" binding:"min=0"`
+}
+
+type batchResult struct {
+	Status int          `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// SetMaxBatchSize sets how many operations a single batch request may contain.
+func (r *Router) SetMaxBatchSize(size int) {
+	r.maxBatchSize = size
+}
+
+// todoOperation is a single create, update or delete in a batch.
+// Id is ignored by create, and Version is the expected version
+// of the todo for update and delete. Zero skips the check.
+type todoOperation struct {
+	Op          string
+	Id          int
+	Name        repository.Updatable[string]
+	Description repository.Updatable[string]
+	Version     int
+}
+
+func (op todoOperation) updater() repository.TodoUpdater {
+	return repository.TodoUpdater{
+		Id:          op.Id,
+		Name:        op.Name,
+		Description: op.Description,
+		Version:     op.Version,
+	}
+}
+
+// toOperation validates the request and converts it into an operation.
+func (op batchOperation) toOperation() (todoOperation, error) {
+	if err := validate(&op); err != nil {
+		return todoOperation{}, err
+	}
+
+	operation := todoOperation{
+		Op:      op.Op,
+		Id:      op.Id,
+		Version: op.Version,
+	}
+	if op.Name != nil {
+		operation.Name = repository.Updatable[string]{Updatable: true, Value: *op.Name}
+	}
+	if op.Description != nil {
+		operation.Description = repository.Updatable[string]{Updatable: true, Value: *op.Description}
+	}
+
+	switch op.Op {
+	case operationCreate:
+		if op.Name == nil {
+			return operation, invalidField("name", "is required for %v", op.Op)
+		}
+	case operationUpdate, operationDelete:
+		if op.Id <= 0 {
+			return operation, invalidField("id", "is required for %v", op.Op)
+		}
+	}
+
+	return operation, nil
+}
+
+// applyOperation applies op through repo, which is the repository of the router
+// or the one given to a unit of work.
+func applyOperation(ctx context.Context, repo repository.TodoListManipulation, op todoOperation, actor string) error {
+	switch op.Op {
+	case operationCreate:
+		todo := repository.TodoResponse{Name: op.Name.Value, Description: op.Description.Value}
+		return repo.PostTodo(ctx, todo, actor)
+	case operationUpdate:
+		return repo.UpdateTodo(ctx, op.Id, op.updater(), actor)
+	default:
+		return repo.DeleteTodo(ctx, uint(op.Id), op.Version, actor)
+	}
+}
+
+func newBatchResults(errs []error) []batchResult {
+	results := make([]batchResult, len(errs))
+	for i, err := range errs {
+		results[i].Status = http.StatusOK
+		if err != nil {
+			results[i].Status = errorStatus(err)
+			results[i].Error = http.StatusText(results[i].Status)
+		}
+
+		var verr *validationError
+		if errors.As(err, &verr) {
+			results[i].Errors = verr.fields
+		}
+	}
+
+	return results
+}
+
+func (r *Router) batchTodo(c *gin.Context) {
+	// gin regards ":batch" as a wildcard, so any "/todos..." path reaches here.
+	if c.Param("batch") != ":batch" {
+		failureHandling(errRouteNotFound, c)
+		return
+	}
+
+	bodyBytes, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	var req batchRequest
+	if err := bindJSON(bodyBytes, &req); err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = batchModeAtomic
+	}
+
+	if len(req.Operations) > r.maxBatchSize {
+		failureHandling(fmt.Errorf("%w: %v > %v", errBatchTooLarge, len(req.Operations), r.maxBatchSize), c)
+		return
+	}
+
+	ops := make([]todoOperation, len(req.Operations))
+	errs := make([]error, len(req.Operations))
+	invalid := false
+	for i, op := range req.Operations {
+		operation, err := op.toOperation()
+		if err != nil {
+			errs[i] = err
+			invalid = true
+		}
+
+		ops[i] = operation
+	}
+
+	ctx := c.Request.Context()
+	actor := r.actor(c)
+
+	if req.Mode == batchModeBestEffort {
+		for i, op := range ops {
+			if errs[i] == nil {
+				errs[i] = applyOperation(ctx, r.repo, op, actor)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"results": newBatchResults(errs)})
+		return
+	}
+
+	if invalid {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = errAborted
+			}
+		}
+
+		abortWithBatchProblem(c, fmt.Errorf("%w: batch has invalid operations", repository.ErrInvalid), errs)
+		return
+	}
+
+	// The whole batch is a unit of work. When one of the operations fails
+	// it is rolled back, the failed operation gets its own error and the others errAborted.
+	failed := -1
+	err = r.repo.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+		// The unit may be retried from the beginning.
+		failed = -1
+		for i, op := range ops {
+			if err := applyOperation(ctx, repo, op, actor); err != nil {
+				failed = i
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		for i := range errs {
+			errs[i] = errAborted
+		}
+		if failed != -1 {
+			errs[failed] = err
+		}
+
+		abortWithBatchProblem(c, err, errs)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": newBatchResults(errs)})
+}
+
+// abortWithBatchProblem reports a rolled back batch as a problem
+// which also has the result of every operation.
+func abortWithBatchProblem(c *gin.Context, err error, errs []error) {
+	status := errorStatus(err)
+	logFailure(c, status, err)
+	abortWithProblem(c, status, struct {
+		problem
+		Results []batchResult `json:"results"`
+	}{
+		problem: newProblem(c, status, err),
+		Results: newBatchResults(errs),
+	})
+}
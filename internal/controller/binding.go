@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const dateLayout = "2006-01-02"
+
+// Request payloads are decoded into structs and validated by their
+// "binding" tags. Besides the rules built in the validator (required, max,
+// oneof, number...) the following rules are available:
+//
+//	notblank           the string is not empty after trimming spaces
+//	daterange=from~to  the date is between from and to, both inclusive.
+//	                   Either bound can be omitted and dates are YYYY-MM-DD.
+func init() {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		panic("unexpected validator engine")
+	}
+
+	// Report fields by the names clients use in the payload.
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "" || name == "-" {
+			return field.Name
+		}
+
+		return name
+	})
+
+	validate.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	validate.RegisterValidation("daterange", validateDateRange)
+}
+
+// validateDateRange accepts time.Time and YYYY-MM-DD strings.
+func validateDateRange(fl validator.FieldLevel) bool {
+	var date time.Time
+	switch v := fl.Field().Interface().(type) {
+	case time.Time:
+		date = v
+	case string:
+		parsed, err := time.Parse(dateLayout, v)
+		if err != nil {
+			return false
+		}
+		date = parsed
+	default:
+		return false
+	}
+
+	bounds := strings.SplitN(fl.Param(), "~", 2)
+	if len(bounds) != 2 {
+		panic(fmt.Sprintf("daterange requires from~to: %v", fl.Param()))
+	}
+
+	day := date.Format(dateLayout)
+	if bounds[0] != "" && day < bounds[0] {
+		return false
+	}
+	if bounds[1] != "" && day > bounds[1] {
+		return false
+	}
+
+	return true
+}
+
+// bindJSON decodes body into req, a pointer to a request struct, and validates it.
+func bindJSON(body []byte, req any) error {
+	if err := json.Unmarshal(body, req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return invalidField(typeErr.Field, "must be %v", typeErr.Type)
+		}
+
+		return err
+	}
+
+	return validate(req)
+}
+
+// validate checks req by its binding tags and reports every invalid field.
+func validate(req any) error {
+	err := binding.Validator.ValidateStruct(req)
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	verr := &validationError{}
+	for _, fe := range errs {
+		verr.fields = append(verr.fields, fieldError{
+			Field:   fieldName(fe),
+			Message: fieldMessage(fe),
+		})
+	}
+
+	return verr
+}
+
+// fieldName strips the name of the request struct from the namespace,
+// e.g. "postTodoRequest.name" becomes "name".
+func fieldName(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i != -1 {
+		return namespace[i+1:]
+	}
+
+	return namespace
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "notblank":
+		return "must not be blank"
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %v characters", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %v", fe.Param())
+	case "min":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must have at least %v items", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %v", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %v", strings.Join(strings.Fields(fe.Param()), ", "))
+	case "number":
+		return "must be a number"
+	case "daterange":
+		return fmt.Sprintf("must be a date in %v", fe.Param())
+	default:
+		return fmt.Sprintf("must satisfy %v", fe.Tag())
+	}
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Soya-Onishi/api-server-go/internal/jsonpatch"
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errRouteNotFound            = errors.New("no such route")
+	errUnauthorized             = errors.New("wrong username or password")
+	errUnsupportedMediaType     = errors.New("unsupported content type")
+	errBatchTooLarge            = errors.New("too many operations in a batch")
+	errIdempotencyKeyReused     = errors.New("idempotency key was used for another request")
+	errIdempotencyKeyInProgress = errors.New("request with the idempotency key is still handled")
+	// errAborted is reported for the operations in a batch which were rolled
+	// back because another operation of the batch failed.
+	errAborted = errors.New("aborted by another operation")
+	// errUnprocessable reports a well-formed request whose result is not a valid todo.
+	errUnprocessable = errors.New("unprocessable entity")
+)
+
+// kindError gives err a kind checked by errorStatus,
+// keeping err reachable to errors.As.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+func (e *kindError) Is(target error) bool {
+	return e.kind == target
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// errorStatus maps an error from the repository or the request preconditions
+// to the HTTP status code of the response.
+func errorStatus(err error) int {
+	var verr *validationError
+
+	switch {
+	case errors.Is(err, errRouteNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, errUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errBatchTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errIdempotencyKeyInProgress):
+		return http.StatusConflict
+	case errors.Is(err, errPreconditionRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, errPreconditionFailed), errors.Is(err, repository.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, errAborted):
+		return http.StatusFailedDependency
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrConflict), errors.Is(err, jsonpatch.ErrConflict), errors.Is(err, jsonpatch.ErrTestFailed):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnprocessable):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &verr):
+		return http.StatusBadRequest
+	case errors.Is(err, repository.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, repository.ErrTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// failureHandling logs err and aborts the request with a problem
+// whose status is mapped by errorStatus.
+func failureHandling(err error, c *gin.Context) {
+	status := errorStatus(err)
+	logFailure(c, status, err)
+	abortWithProblem(c, status, newProblem(c, status, err))
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultReadinessTimeout = 2 * time.Second
+
+var errShuttingDown = errors.New("server is shutting down")
+
+// readinessCheck is a dependency which must be available
+// for the server to handle requests.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// checkStatus tells only whether the check passes. Its error is logged
+// instead, since it may have hosts and messages of the drivers.
+type checkStatus struct {
+	Status string `json:"status"`
+}
+
+type readinessResponse struct {
+	Status    string                 `json:"status"`
+	Error     string                 `json:"error,omitempty"`
+	Checks    map[string]checkStatus `json:"checks"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// AddReadinessCheck makes /readyz fail while check returns an error.
+// check is given a context which expires after the readiness timeout.
+func (r *Router) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	r.readinessChecks = append(r.readinessChecks, readinessCheck{name: name, check: check})
+}
+
+// SetReadinessTimeout sets how long /readyz waits for each check.
+func (r *Router) SetReadinessTimeout(timeout time.Duration) {
+	r.readinessTimeout = timeout
+}
+
+// SetReadinessGrace sets how long the server keeps serving with /readyz
+// failing before it stops accepting connections on shutdown.
+func (r *Router) SetReadinessGrace(grace time.Duration) {
+	r.readinessGrace = grace
+}
+
+// liveness reports that the process is able to handle requests at all.
+func (r *Router) liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readiness runs every check at once and reports each of them.
+// It fails without running the checks once the server starts shutting down,
+// so that load balancers stop sending new requests.
+func (r *Router) readiness(c *gin.Context) {
+	if atomic.LoadInt32(&r.draining) != 0 {
+		c.JSON(http.StatusServiceUnavailable, readinessResponse{
+			Status:    "unavailable",
+			Error:     errShuttingDown.Error(),
+			Checks:    map[string]checkStatus{},
+			RequestID: c.GetString(requestIDKey),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), r.readinessTimeout)
+	defer cancel()
+
+	statuses := make([]checkStatus, len(r.readinessChecks))
+	var wg sync.WaitGroup
+	for i, check := range r.readinessChecks {
+		wg.Add(1)
+		go func(i int, check readinessCheck) {
+			defer wg.Done()
+
+			statuses[i].Status = "ok"
+			if err := check.check(ctx); err != nil {
+				statuses[i].Status = "failing"
+				loggerOf(c).Warn("readiness check failed", "check", check.name, "error", err)
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	resp := readinessResponse{Status: "ok", Checks: map[string]checkStatus{}}
+	status := http.StatusOK
+	for i, check := range r.readinessChecks {
+		resp.Checks[check.name] = statuses[i]
+		if statuses[i].Status != "ok" {
+			resp.Status = "unavailable"
+			resp.RequestID = c.GetString(requestIDKey)
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(status, resp)
+}
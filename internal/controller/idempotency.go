@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/logging"
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultIdempotencyWindow = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+// keyedMutex serializes requests which share the same key.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu      sync.Mutex
+	waiters int
+}
+
+func newKeyedMutex() *keyedMutex {
+	m := new(keyedMutex)
+	m.locks = make(map[string]*keyLock)
+
+	return m
+}
+
+func (m *keyedMutex) Lock(key string) {
+	m.mu.Lock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = new(keyLock)
+		m.locks[key] = l
+	}
+	l.waiters++
+	m.mu.Unlock()
+
+	l.mu.Lock()
+}
+
+func (m *keyedMutex) Unlock(key string) {
+	m.mu.Lock()
+	l := m.locks[key]
+	l.waiters--
+	if l.waiters == 0 {
+		delete(m.locks, key)
+	}
+	m.mu.Unlock()
+
+	l.mu.Unlock()
+}
+
+// recordingWriter keeps a copy of the response body written by the handler.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// SetIdempotencyWindow sets how long the response for an Idempotency-Key is replayed.
+func (r *Router) SetIdempotencyWindow(window time.Duration) {
+	r.idempotencyWindow = window
+}
+
+// replayedHeaders are the response headers stored with the body
+// and sent again when the response is replayed.
+var replayedHeaders = []string{"Content-Type", "ETag", "Last-Modified", "Location"}
+
+// idempotent makes the handler replay its first response for requests
+// carrying the same Idempotency-Key header from the same actor.
+// The key is reserved in the repository before the handler runs,
+// so that only one of the servers sharing it handles the request.
+func (r *Router) idempotent(c *gin.Context) {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		c.Next()
+		return
+	}
+
+	if len(key) > maxIdempotencyKeyLength {
+		errorHandling(invalidField("Idempotency-Key", "must not be longer than %v", maxIdempotencyKeyLength), c)
+		return
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	requestHash := fmt.Sprintf("%x", sha256.Sum256(
+		[]byte(fmt.Sprintf("%v %v\n%s", c.Request.Method, c.Request.URL.Path, body)),
+	))
+
+	actor := r.actor(c)
+	lockKey := actor + "\n" + key
+	r.idempotencyLocks.Lock(lockKey)
+	defer r.idempotencyLocks.Unlock(lockKey)
+
+	since := time.Now().Add(-r.idempotencyWindow)
+	reservation := repository.IdempotentResponse{Actor: actor, Key: key, RequestHash: requestHash}
+	err = r.repo.ReserveIdempotentResponse(c.Request.Context(), reservation, since)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrConflict):
+		r.replay(c, reservation, since)
+		return
+	default:
+		failureHandling(err, c)
+		return
+	}
+
+	// The reservation is released unless the response is saved,
+	// such as when the handler panics, so that the client can retry.
+	// Both are done even when the client has gone away, since the client
+	// is likely to retry then and would be rejected until the window ends.
+	ctx := detachedContext(c)
+	saved := false
+	defer func() {
+		if saved {
+			return
+		}
+		if err := r.repo.ReleaseIdempotentResponse(ctx, actor, key); err != nil {
+			loggerOf(c).Error("failed to release idempotency key", "error", err)
+		}
+	}()
+
+	writer := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+
+	c.Next()
+
+	// Server errors are not stored so that the client can retry them.
+	if writer.Status() >= http.StatusInternalServerError {
+		return
+	}
+
+	header := map[string]string{}
+	for _, name := range replayedHeaders {
+		if value := writer.Header().Get(name); value != "" {
+			header[name] = value
+		}
+	}
+
+	reservation.Status = writer.Status()
+	reservation.Header = header
+	reservation.Body = writer.body.Bytes()
+	if err := r.repo.SaveIdempotentResponse(ctx, reservation); err != nil {
+		loggerOf(c).Error("failed to save idempotent response", "error", err)
+		return
+	}
+	saved = true
+}
+
+// detachedContext returns a context which is not canceled with the request
+// but keeps its logger. The repository bounds it by its write timeout.
+func detachedContext(c *gin.Context) context.Context {
+	return logging.NewContext(context.Background(), loggerOf(c))
+}
+
+// replay sends the response stored for the key of the request again.
+// A key whose first request is still handled, possibly by another server,
+// is rejected with 409 Conflict.
+func (r *Router) replay(c *gin.Context, request repository.IdempotentResponse, since time.Time) {
+	stored, err := r.repo.GetIdempotentResponse(c.Request.Context(), request.Actor, request.Key, since)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		// The first request failed and released the key just now.
+		failureHandling(errIdempotencyKeyInProgress, c)
+		return
+	case err != nil:
+		failureHandling(err, c)
+		return
+	}
+
+	if stored.RequestHash != request.RequestHash {
+		failureHandling(errIdempotencyKeyReused, c)
+		return
+	}
+	if stored.Status == 0 {
+		failureHandling(errIdempotencyKeyInProgress, c)
+		return
+	}
+
+	for name, value := range stored.Header {
+		c.Header(name, value)
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(stored.Status, stored.Header["Content-Type"], stored.Body)
+	c.Abort()
+}
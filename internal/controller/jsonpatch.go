@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/Soya-Onishi/api-server-go/internal/jsonpatch"
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// jsonPatchTodo applies a JSON Patch (RFC 6902) to the JSON representation of the todo.
+// The patched representation is validated as if it was sent by PUT and is stored
+// only if the todo has not been modified since it was read.
+func (r *Router) jsonPatchTodo(c *gin.Context, id uint) {
+	expected, err := r.expectedVersion(c, id)
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	bodyBytes, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	patch, err := jsonpatch.Decode(bodyBytes)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	actor := r.actor(c)
+
+	// The todo is read, patched and written in one unit of work,
+	// so the response is the todo exactly as this patch left it.
+	var updated *repository.TodoResponse
+	err = r.repo.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+		current, err := repo.GetTodo(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if expected != 0 && current.Version != expected {
+			return errPreconditionFailed
+		}
+
+		todo, err := applyPatch(patch, current)
+		if err != nil {
+			return err
+		}
+		todo.Version = current.Version
+
+		err = repo.UpdateTodo(ctx, int(id), todo, actor)
+		if errors.Is(err, repository.ErrVersionMismatch) && expected == 0 {
+			// The todo was modified by another request while the patch was applied.
+			err = fmt.Errorf("%w: todo %v was modified concurrently", repository.ErrConflict, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		updated, err = repo.GetTodo(ctx, id)
+		return err
+	})
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	respondTodo(c, updated)
+}
+
+// applyPatch applies the patch to the JSON representation of the todo
+// and returns the updater replacing the todo with the result.
+func applyPatch(patch jsonpatch.Patch, current *repository.TodoResponse) (repository.TodoUpdater, error) {
+	doc := map[string]any{
+		"id":          strconv.Itoa(current.Id),
+		"name":        current.Name,
+		"description": current.Description,
+	}
+
+	patched, err := patch.Apply(doc)
+	if err != nil {
+		return repository.TodoUpdater{}, err
+	}
+
+	patchedBytes, err := json.Marshal(patched)
+	if err != nil {
+		return repository.TodoUpdater{}, err
+	}
+
+	todo, err := decodeReplacement(patchedBytes, current.Id)
+	if err != nil {
+		return repository.TodoUpdater{}, &kindError{kind: errUnprocessable, err: err}
+	}
+
+	return todo, nil
+}
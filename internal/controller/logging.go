@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/logging"
+	"github.com/gin-gonic/gin"
+)
+
+const loggerKey = "logger"
+
+// SetLogger sets the logger of the requests, to which the ID of each request is added.
+func (r *Router) SetLogger(logger *logging.Logger) {
+	r.logger = logger
+}
+
+// loggerOf returns the logger of the request, which has its ID.
+func loggerOf(c *gin.Context) *logging.Logger {
+	logger, _ := c.Value(loggerKey).(*logging.Logger)
+	return logger
+}
+
+// logRequest writes an access log of the request once it is handled.
+func (r *Router) logRequest(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	loggerOf(c).Info("request",
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", c.Writer.Status(),
+		"duration", time.Since(start),
+		"client_ip", c.ClientIP(),
+	)
+}
+
+// recovered answers a request whose handler panicked with 500 Internal Server Error,
+// and logs the stack where it panicked.
+func (r *Router) recovered(c *gin.Context, err interface{}) {
+	status := http.StatusInternalServerError
+	loggerOf(c).Error("request failed", "status", status, "error", fmt.Errorf("panic: %v", err), "stack", string(debug.Stack()))
+	abortWithProblem(c, status, newProblem(c, status, nil))
+}
+
+// logFailure logs err which is answered with status. Server errors are
+// logged as errors, while the others are caused by the client.
+func logFailure(c *gin.Context, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		loggerOf(c).Error("request failed", "status", status, "error", err)
+	} else {
+		loggerOf(c).Info("request rejected", "status", status, "error", err)
+	}
+}
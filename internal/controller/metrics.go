@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+type routerMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec
+	latency  *metrics.HistogramVec
+	logins   *metrics.CounterVec
+}
+
+// SetMetrics records every request and login to registry,
+// and serves registry at /metrics.
+func (r *Router) SetMetrics(registry *metrics.Registry) {
+	m := new(routerMetrics)
+	m.registry = registry
+	m.requests = registry.NewCounterVec("http_requests_total", "Number of HTTP requests by route and status class.", "method", "route", "status")
+	m.latency = registry.NewHistogramVec("http_request_duration_seconds", "Latency of HTTP requests by route.", metrics.DefaultBuckets, "method", "route")
+	m.logins = registry.NewCounterVec("login_attempts_total", "Number of login attempts by result.", "result")
+
+	r.metrics = m
+}
+
+// instrument measures the request. Requests to unknown paths share
+// one route so that they cannot add series without limit.
+func (r *Router) instrument(c *gin.Context) {
+	if r.metrics == nil {
+		c.Next()
+		return
+	}
+
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	status := fmt.Sprintf("%dxx", c.Writer.Status()/100)
+
+	r.metrics.latency.Observe(time.Since(start).Seconds(), c.Request.Method, route)
+	r.metrics.requests.Inc(c.Request.Method, route, status)
+}
+
+// countLogin records a login which succeeded or was rejected for its credentials.
+func (r *Router) countLogin(success bool) {
+	if r.metrics == nil {
+		return
+	}
+
+	if success {
+		r.metrics.logins.Inc("success")
+	} else {
+		r.metrics.logins.Inc("failure")
+	}
+}
+
+func (r *Router) serveMetrics(c *gin.Context) {
+	if r.metrics == nil {
+		r.noRoute(c)
+		return
+	}
+
+	r.metrics.registry.ServeHTTP(c.Writer, c.Request)
+}
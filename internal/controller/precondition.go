@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+var errPreconditionFailed = errors.New("precondition failed")
+var errPreconditionRequired = errors.New("precondition required")
+
+func formatETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// formatListETag includes the largest id, so that the tag does not return to
+// an earlier value when todos are created and others are purged.
+func formatListETag(metadata *repository.TodoListMetadata) string {
+	return fmt.Sprintf(`W/"%d-%d-%d"`, metadata.Count, metadata.VersionSum, metadata.MaxID)
+}
+
+// matchETag reports whether etag is listed in the If-Match header value.
+// Weak tags never match because If-Match uses the strong comparison.
+func matchETag(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expectedVersion evaluates the If-Match header against the todo
+// and returns the version the write must be applied to.
+// Zero means the request has no precondition.
+func (r *Router) expectedVersion(c *gin.Context, id uint) (int, error) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		if r.strictPrecondition {
+			return 0, errPreconditionRequired
+		}
+
+		return 0, nil
+	}
+
+	todo, err := r.repo.GetTodo(c.Request.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return 0, errPreconditionFailed
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if !matchETag(header, formatETag(todo.Version)) {
+		return 0, errPreconditionFailed
+	}
+
+	return todo.Version, nil
+}
+
+// setETag sets the ETag of the current version of the todo to the response.
+func (r *Router) setETag(c *gin.Context, id uint) {
+	if todo, err := r.repo.GetTodo(c.Request.Context(), id); err == nil {
+		c.Header("ETag", formatETag(todo.Version))
+	}
+}
+
+// matchWeakETag reports whether etag is listed in the If-None-Match header value.
+// If-None-Match uses the weak comparison, so W/ prefixes are ignored.
+func matchWeakETag(header string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified sets the validators to the response and reports whether
+// the client already has the current representation.
+// If-Modified-Since is evaluated only when If-None-Match is absent.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if header := c.GetHeader("If-None-Match"); header != "" {
+		return matchWeakETag(header, etag)
+	}
+
+	if header := c.GetHeader("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			return false
+		}
+
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
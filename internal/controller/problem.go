@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Soya-Onishi/api-server-go/internal/logging"
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const mimeProblem = "application/problem+json"
+
+const requestIDKey = "request_id"
+
+// problem is the body of every error response (RFC 7807).
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance"`
+	RequestID string       `json:"request_id"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError tells which member of the request is invalid and why.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationError rejects a request because of its fields.
+type validationError struct {
+	fields []fieldError
+}
+
+func (e *validationError) Error() string {
+	messages := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		messages[i] = fmt.Sprintf("%v: %v", f.Field, f.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func invalidField(field string, format string, args ...any) error {
+	return &validationError{fields: []fieldError{{Field: field, Message: fmt.Sprintf(format, args...)}}}
+}
+
+// maxRequestIDLength bounds the X-Request-ID accepted from the client.
+const maxRequestIDLength = 128
+
+// requestID gives every request an ID which is returned in the X-Request-ID header,
+// in error responses and in every log line of the request, so that a failure can be found in the logs.
+// An ID sent by the client or a proxy is kept so that the request can be followed across servers.
+func (r *Router) requestID(c *gin.Context) {
+	id := c.GetHeader("X-Request-ID")
+	if !validRequestID(id) {
+		id = uuid.New().String()
+	}
+	c.Set(requestIDKey, id)
+	c.Header("X-Request-ID", id)
+
+	logger := r.logger.With(requestIDKey, id)
+	c.Set(loggerKey, logger)
+	c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), logger))
+
+	c.Next()
+}
+
+// validRequestID reports whether id is short and made only of letters,
+// digits and "-_.:", so that it cannot forge anything in the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, ch := range id {
+		switch {
+		case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9':
+		case strings.ContainsRune("-_.:", ch):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// newProblem describes err as a problem of the given status.
+// Details of server errors are not exposed to the client.
+func newProblem(c *gin.Context, status int, err error) problem {
+	p := problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  c.Request.URL.RequestURI(),
+		RequestID: c.GetString(requestIDKey),
+	}
+
+	var verr *validationError
+	if errors.As(err, &verr) {
+		p.Errors = verr.fields
+	}
+
+	if err != nil && status < http.StatusInternalServerError {
+		p.Detail = problemDetail(err, verr != nil)
+	}
+
+	return p
+}
+
+// problemDetail returns the detail of a client error. Errors from the repository
+// may carry messages of the database driver, so only their kind is told.
+func problemDetail(err error, validation bool) string {
+	var repoErr *repository.Error
+	switch {
+	case validation:
+		return err.Error()
+	case errors.Is(err, repository.ErrVersionMismatch):
+		return repository.ErrVersionMismatch.Err.Error()
+	case errors.As(err, &repoErr):
+		return repoErr.Kind.Error()
+	default:
+		return err.Error()
+	}
+}
+
+// abortWithProblem aborts the request with body, which is a problem
+// or a struct embedding it to add extension members.
+func abortWithProblem(c *gin.Context, status int, body any) {
+	c.Header("Content-Type", mimeProblem)
+	c.AbortWithStatusJSON(status, body)
+}
+
+func (r *Router) noRoute(c *gin.Context) {
+	failureHandling(errRouteNotFound, c)
+}
@@ -1,50 +1,153 @@
 package controller
 
 import (
+	"context"
 	"crypto/sha256"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
-	"os"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/Soya-Onishi/api-server-go/internal/logging"
 	"github.com/Soya-Onishi/api-server-go/internal/repository"
 	"github.com/gin-gonic/gin"
 )
 
 type Router struct {
-	engine *gin.Engine
-	repo   repository.TodoListManipulation
+	engine             *gin.Engine
+	repo               repository.TodoListManipulation
+	strictPrecondition bool
+	idempotencyWindow  time.Duration
+	idempotencyLocks   *keyedMutex
+	maxBatchSize       int
+	cookie             CookieOptions
+	readinessChecks    []readinessCheck
+	readinessTimeout   time.Duration
+	readinessGrace     time.Duration
+	metrics            *routerMetrics
+	logger             *logging.Logger
+	// draining is set to 1 once the server starts shutting down.
+	draining int32
+}
+
+// CookieOptions are the attributes of the session cookies set on login.
+// The cookies are always HttpOnly.
+type CookieOptions struct {
+	MaxAge time.Duration
+	Path   string
+	Domain string
+	Secure bool
 }
 
 func NewRouter(engine *gin.Engine, repo repository.TodoListManipulation) *Router {
 	r := new(Router)
 	r.engine = engine
 	r.repo = repo
+	r.idempotencyWindow = defaultIdempotencyWindow
+	r.idempotencyLocks = newKeyedMutex()
+	r.maxBatchSize = defaultMaxBatchSize
+	r.cookie = CookieOptions{MaxAge: 24 * time.Hour, Path: "/"}
+	r.readinessTimeout = defaultReadinessTimeout
+	r.logger = logging.Default()
 
 	r.setRouter(engine)
 
 	return r
 }
 
-func (r *Router) Run() {
-	r.engine.Run()
+// Run listens on addr and serves the requests until ctx is done.
+// See Serve for how the server stops.
+func (r *Router) Run(ctx context.Context, addr string, drain time.Duration) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return r.Serve(ctx, listener, drain)
+}
+
+// Serve serves the requests accepted by listener until ctx is done.
+// Then /readyz starts failing, and new requests are still served for the
+// readiness grace so that load balancers notice it and stop sending them.
+// After that the listener is closed so that no new connection is accepted,
+// and the requests in flight are given drain to complete.
+// Connections still active after that are closed and an error is returned.
+func (r *Router) Serve(ctx context.Context, listener net.Listener, drain time.Duration) error {
+	server := new(http.Server)
+	server.Handler = r.engine
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	atomic.StoreInt32(&r.draining, 1)
+	r.logger.Info("shutting down", "grace", r.readinessGrace, "drain", drain)
+
+	select {
+	case err := <-served:
+		return err
+	case <-time.After(r.readinessGrace):
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("connections are not drained: %w", err)
+	}
+
+	return nil
 }
 
 func (r *Router) GetEngine() *gin.Engine {
 	return r.engine
 }
 
+// SetStrictPrecondition makes writes to a todo without If-Match header
+// fail with 428 Precondition Required.
+func (r *Router) SetStrictPrecondition(strict bool) {
+	r.strictPrecondition = strict
+}
+
+// SetCookieOptions sets the attributes of the session cookies.
+func (r *Router) SetCookieOptions(opts CookieOptions) {
+	r.cookie = opts
+}
+
 func (r *Router) setRouter(e *gin.Engine) {
+	// instrument wraps the recovery so that panicked requests are measured as well.
+	e.Use(r.requestID, r.logRequest, r.instrument, gin.CustomRecoveryWithWriter(ioutil.Discard, r.recovered))
+	e.NoRoute(r.noRoute)
+
 	e.GET("/", r.helloHandler)
+	e.GET("/healthz", r.liveness)
+	e.GET("/readyz", r.readiness)
+	e.GET("/metrics", r.serveMetrics)
 	e.GET("/todos", r.returnTodo)
-	e.POST("/todos", r.postTodo)
+	e.GET("/todos/search", r.searchTodo)
+	e.GET("/todos/:id", r.returnTodoItem)
+	e.POST("/todos", r.idempotent, r.postTodo)
+	e.POST("/todos:batch", r.batchTodo)
 	e.DELETE("/todos", r.deleteTodo)
 	e.PATCH("/todos", r.updateTodo)
+	e.PUT("/todos/:id", r.putTodo)
+	e.PATCH("/todos/:id", r.patchTodo)
+	e.GET("/trash", r.returnTrash)
+	e.POST("/trash/:id/restore", r.restoreTodo)
+	e.GET("/todos/:id/history", r.returnTodoHistory)
+	e.POST("/todos/:id/revert", r.revertTodo)
 	e.POST("/login", r.login)
 }
 
@@ -55,17 +158,29 @@ func (r *Router) helloHandler(c *gin.Context) {
 }
 
 func (r *Router) returnTodo(c *gin.Context) {
-	todos := r.repo.GetAllTodos()
-	if todos == nil {
-		c.AbortWithStatus(http.StatusServiceUnavailable)
+	metadata, err := r.repo.GetTodoListMetadata(c.Request.Context())
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	if notModified(c, formatListETag(metadata), metadata.LastModified) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	todos, err := r.repo.GetAllTodos(c.Request.Context())
+	if err != nil {
+		failureHandling(err, c)
 		return
 	}
 
 	resp := []map[string]string{}
 	for _, todo := range todos {
 		m := map[string]string{
-			"id":   strconv.Itoa(todo.Id),
-			"name": todo.Name,
+			"id":          strconv.Itoa(todo.Id),
+			"name":        todo.Name,
+			"description": todo.Description,
 		}
 
 		resp = append(resp, m)
@@ -74,78 +189,139 @@ func (r *Router) returnTodo(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+func (r *Router) returnTodoItem(c *gin.Context) {
+	id, err := getParamID(c)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	todo, err := r.repo.GetTodo(c.Request.Context(), id)
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	if notModified(c, formatETag(todo.Version), todo.UpdatedAt) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]string{
+		"id":          strconv.Itoa(todo.Id),
+		"name":        todo.Name,
+		"description": todo.Description,
+	})
+}
+
 func errorHandling(err error, c *gin.Context) {
-	log.SetOutput(os.Stderr)
-	log.SetPrefix("[ERROR]")
-	log.Printf("%v", err)
+	logFailure(c, http.StatusBadRequest, err)
+	abortWithProblem(c, http.StatusBadRequest, newProblem(c, http.StatusBadRequest, err))
+}
 
-	c.AbortWithStatus(http.StatusBadRequest)
+// postTodoRequest is the body of POST /todos.
+type postTodoRequest struct {
+	Id          string  `json:"id" binding:"required,number"`
+	Name        *string `json:"name" binding:"required,notblank,max=128"`
+	Description string  `json:"description" binding:"max=1024"`
 }
 
 func (r *Router) postTodo(c *gin.Context) {
-	var reqBody map[string]string
 	reqBytes, err := ioutil.ReadAll(c.Request.Body)
-
 	if err != nil {
 		errorHandling(err, c)
 		return
 	}
 
-	if err := json.Unmarshal(reqBytes, &reqBody); err != nil {
+	var req postTodoRequest
+	if err := bindJSON(reqBytes, &req); err != nil {
 		errorHandling(err, c)
 		return
 	}
 
-	id, err := strconv.Atoi(reqBody["id"])
+	id, err := strconv.Atoi(req.Id)
 	if err != nil {
-		errorHandling(err, c)
-		return
-	}
-
-	title, ok := reqBody["name"]
-	if !ok {
-		c.AbortWithStatus(http.StatusBadRequest)
+		errorHandling(invalidField("id", "must be a number"), c)
 		return
 	}
 
 	todo := repository.TodoResponse{
-		Id:   id,
-		Name: title,
+		Id:          id,
+		Name:        *req.Name,
+		Description: req.Description,
 	}
 
-	status := r.repo.PostTodo(todo)
+	if err := r.repo.PostTodo(c.Request.Context(), todo, r.actor(c)); err != nil {
+		failureHandling(err, c)
+		return
+	}
 
-	c.JSON(status, map[string]string{})
+	c.JSON(http.StatusOK, map[string]string{})
 }
 
 func getQueryID(c *gin.Context) (int, error) {
 	idString, ok := c.GetQuery("id")
 	if !ok {
-		return -1, errors.New("query id does not exists")
+		return -1, invalidField("id", "is required")
 	}
 
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		return -1, err
+		return -1, invalidField("id", "must be a number")
 	}
 
 	return id, nil
 }
 
+func getParamID(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		return 0, invalidField("id", "must be a number: %v", c.Param("id"))
+	}
+
+	return uint(id), nil
+}
+
+const anonymousActor = "anonymous"
+
+// actor returns the name of the logged in user who sends the request.
+// Requests without a valid session are attributed to anonymousActor.
+func (r *Router) actor(c *gin.Context) string {
+	username, err := c.Cookie("Username")
+	if err != nil {
+		return anonymousActor
+	}
+
+	sessionHash, err := c.Cookie("SessionHash")
+	if err != nil {
+		return anonymousActor
+	}
+
+	hash, err := r.repo.GetSessionHash(c.Request.Context(), username)
+	if err != nil || hash == nil || fmt.Sprintf("%x", *hash) != sessionHash {
+		return anonymousActor
+	}
+
+	return username
+}
+
 func (r *Router) deleteTodo(c *gin.Context) {
-	idString, ok := c.GetQuery("id")
-	if !ok {
-		errorHandling(errors.New("query id does not exists"), c)
+	id, err := getQueryID(c)
+	if err != nil {
+		errorHandling(err, c)
 		return
 	}
 
-	id, err := strconv.Atoi(idString)
+	version, err := r.expectedVersion(c, uint(id))
 	if err != nil {
-		errorHandling(err, c)
+		failureHandling(err, c)
 		return
 	}
 
-	r.repo.DeleteTodo(uint(id))
+	if err := r.repo.DeleteTodo(c.Request.Context(), uint(id), version, r.actor(c)); err != nil {
+		failureHandling(err, c)
+		return
+	}
 
 	c.JSON(http.StatusOK, map[string]string{})
 }
@@ -157,20 +333,122 @@ func (r *Router) updateTodo(c *gin.Context) {
 		return
 	}
 
-	bodyBytes, _ := ioutil.ReadAll(c.Request.Body)
-	var body map[string]string
-	json.Unmarshal(bodyBytes, &body)
+	version, err := r.expectedVersion(c, uint(id))
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	bodyBytes, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	todo, err := decodeMergePatch(bodyBytes, id)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+	todo.Version = version
 
-	title, okTitle := body["name"]
-	todo := repository.TodoUpdater{
-		Id: id,
-		Name: repository.Updatable[string]{
-			Updatable: okTitle,
-			Value:     title,
-		},
+	if err := r.repo.UpdateTodo(c.Request.Context(), id, todo, r.actor(c)); err != nil {
+		failureHandling(err, c)
+		return
 	}
 
-	r.repo.UpdateTodo(id, todo)
+	r.setETag(c, uint(id))
+}
+
+func (r *Router) returnTrash(c *gin.Context) {
+	todos, err := r.repo.GetTrash(c.Request.Context())
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	resp := []map[string]string{}
+	for _, todo := range todos {
+		m := map[string]string{
+			"id":          strconv.Itoa(todo.Id),
+			"name":        todo.Name,
+			"description": todo.Description,
+			"deleted_at":  todo.DeletedAt.UTC().Format(time.RFC3339),
+		}
+
+		resp = append(resp, m)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (r *Router) restoreTodo(c *gin.Context) {
+	id, err := getParamID(c)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	if err := r.repo.RestoreTodo(c.Request.Context(), id, r.actor(c)); err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]string{})
+}
+
+func (r *Router) returnTodoHistory(c *gin.Context) {
+	id, err := getParamID(c)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	revisions, err := r.repo.GetTodoHistory(c.Request.Context(), id)
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	resp := []gin.H{}
+	for _, rev := range revisions {
+		resp = append(resp, gin.H{
+			"rev":        rev.Rev,
+			"action":     rev.Action,
+			"actor":      rev.Actor,
+			"created_at": rev.CreatedAt.UTC().Format(time.RFC3339),
+			"diff":       rev.Diff,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (r *Router) revertTodo(c *gin.Context) {
+	id, err := getParamID(c)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	rev, err := strconv.Atoi(c.Query("rev"))
+	if err != nil || rev <= 0 {
+		errorHandling(invalidField("rev", "must be a positive number"), c)
+		return
+	}
+
+	if err := r.repo.RevertTodo(c.Request.Context(), id, rev, r.actor(c)); err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]string{})
+}
+
+// loginRequest is the body of POST /login.
+type loginRequest struct {
+	Username string `json:"username" binding:"required,max=64"`
+	Password string `json:"password" binding:"required"`
 }
 
 func (r *Router) login(c *gin.Context) {
@@ -186,31 +464,42 @@ func (r *Router) login(c *gin.Context) {
 		return
 	}
 
-	body := make(map[string]string)
-	if err := json.Unmarshal(req, &body); err != nil {
+	var body loginRequest
+	if err := bindJSON(req, &body); err != nil {
 		errorHandling(err, c)
 		return
 	}
 
-	username := body["username"]
-	password := body["password"]
+	username := body.Username
+	password := body.Password
 	passHash := sha256.Sum256([]byte(password))
-	userinfo, status := r.repo.GetUserInfo(username)
-
-	if userinfo == nil {
-		c.JSON(status, map[string]string{})
+	userinfo, err := r.repo.GetUserInfo(c.Request.Context(), username)
+	if errors.Is(err, repository.ErrNotFound) {
+		r.countLogin(false)
+		failureHandling(errUnauthorized, c)
+		return
+	}
+	if err != nil {
+		failureHandling(err, c)
 		return
 	}
 
 	if *userinfo.HashedPassword != passHash {
-		c.JSON(http.StatusUnauthorized, map[string]string{})
+		r.countLogin(false)
+		failureHandling(errUnauthorized, c)
+		return
 	}
 
 	sessionHash := createSessionHash(username)
-	r.repo.SetSessionHash(username, sessionHash)
+	if err := r.repo.SetSessionHash(c.Request.Context(), username, sessionHash); err != nil {
+		failureHandling(err, c)
+		return
+	}
 	hashForCookie := fmt.Sprintf("%x", sessionHash)
-	c.SetCookie("Username", username, 60*60*24, "/", "", false, true)
-	c.SetCookie("SessionHash", hashForCookie, 60*60*24, "/", "", false, true)
+	maxAge := int(r.cookie.MaxAge / time.Second)
+	c.SetCookie("Username", username, maxAge, r.cookie.Path, r.cookie.Domain, r.cookie.Secure, true)
+	c.SetCookie("SessionHash", hashForCookie, maxAge, r.cookie.Path, r.cookie.Domain, r.cookie.Secure, true)
+	r.countLogin(true)
 
 	c.JSON(http.StatusOK, map[string]string{})
 }
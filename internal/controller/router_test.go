@@ -2,101 +2,31 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Soya-Onishi/api-server-go/internal/logging"
+	"github.com/Soya-Onishi/api-server-go/internal/metrics"
 	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/Soya-Onishi/api-server-go/internal/repository/memory"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
-type RepositoryMock struct {
-	todos []repository.TodoResponse
-	users []testUserInfo
-}
-
-func (r *RepositoryMock) GetAllTodos() []repository.TodoResponse {
-	return r.todos
-}
-
-func (r *RepositoryMock) PostTodo(todo repository.TodoResponse) int {
-	r.todos = append(r.todos, todo)
-	return http.StatusOK
-}
-
-func (r *RepositoryMock) DeleteTodo(id uint) int {
-	var idx int = -1
-	for i, todo := range r.todos {
-		if uint(todo.Id) == id {
-			idx = i
-			break
-		}
-	}
-
-	if idx != -1 {
-		deleted := append(r.todos[:idx], r.todos[idx+1:]...)
-		r.todos = deleted
-	}
-
-	return http.StatusOK
-}
-
-func (r *RepositoryMock) UpdateTodo(id int, todo repository.TodoUpdater) int {
-	var idx int = -1
-	for i, todo := range r.todos {
-		if todo.Id == id {
-			idx = i
-			break
-		}
-	}
-
-	if idx != -1 {
-		if todo.Name.Updatable {
-			r.todos[idx].Name = todo.Name.Value
-		}
-	}
-
-	return http.StatusOK
-}
-
-func (r *RepositoryMock) GetUserInfo(username string) (*repository.UserInfo, int) {
-	var user repository.UserInfo
-	for _, u := range r.users {
-		if u.Username == username {
-			user.Username = u.Username
-			user.HashedPassword = &u.Password
-			return &user, http.StatusOK
-		}
-	}
-
-	return nil, http.StatusUnauthorized
-}
-
-func (r *RepositoryMock) GetSessionHash(username string) (*[32]byte, int) {
-	for _, u := range r.users {
-		if u.Username == username {
-			return u.SessionHash, http.StatusOK
-		}
-	}
-
-	return nil, http.StatusUnauthorized
-}
-
-func (r *RepositoryMock) SetSessionHash(username string, hash [32]byte) int {
-	for _, u := range r.users {
-		if u.Username == username {
-			u.SessionHash = &hash
-			return http.StatusOK
-		}
-	}
-
-	return http.StatusUnauthorized
-}
+var mockCreatedAt = time.Date(2022, 4, 1, 9, 0, 0, 0, time.UTC)
 
 var initDBData = []repository.TodoResponse{
 	{
@@ -113,51 +43,29 @@ var initDBData = []repository.TodoResponse{
 	},
 }
 
-type testUserInfo struct {
-	Username    string
-	Password    [32]byte
-	SessionHash *[32]byte
-}
-
-var initUserInfo = []testUserInfo{
-	{
-		Username:    "Taro",
-		Password:    sha256.Sum256([]byte("Taro")),
-		SessionHash: nil,
-	},
-	{
-		Username:    "Hanako",
-		Password:    sha256.Sum256([]byte("Hanako")),
-		SessionHash: nil,
-	},
-	{
-		Username:    "Ryota",
-		Password:    sha256.Sum256([]byte("Ryota")),
-		SessionHash: nil,
-	},
-}
+var initUserInfo = []string{"Taro", "Hanako", "Ryota"}
 
-func setupMock() *Router {
-	data := make([]repository.TodoResponse, len(initDBData))
-	users := make([]testUserInfo, len(initUserInfo))
+// seed is the state every test starts from. The todos have no revisions
+// and were last modified at mockCreatedAt.
+var seed = func() *memory.Snapshot {
+	repo := memory.NewRepository()
+	repo.SetClock(func() time.Time { return mockCreatedAt })
 
-	for i, todo := range initDBData {
-		data[i].Id = todo.Id
-		data[i].Name = todo.Name
+	for _, todo := range initDBData {
+		repo.AddTodo(todo.Name, todo.Description)
 	}
-
-	for i, user := range initUserInfo {
-		users[i].Username = user.Username
-		users[i].Password = user.Password
-		users[i].SessionHash = user.SessionHash
+	for _, user := range initUserInfo {
+		repo.AddUser(user, sha256.Sum256([]byte(user)))
 	}
 
-	mock := RepositoryMock{
-		todos: data,
-		users: users,
-	}
+	return repo.Snapshot()
+}()
+
+func setupMock() *Router {
+	repo := memory.NewRepository()
+	repo.Restore(seed)
 
-	return NewRouter(gin.Default(), &mock)
+	return NewRouter(gin.Default(), repo)
 }
 
 func post(url string, content func() []byte) (*http.Response, error) {
@@ -482,6 +390,28 @@ func TestUpdateTodo(t *testing.T) {
 		})
 	})
 
+	t.Run("update not existing todo cause not found", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, err := update(fmt.Sprintf("%v/todos?id=%v", ts.URL, 10), []byte(`{"name":"it's done"}`))
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+			todos := getTodo(ts)
+			assert.Equal(t, len(initDBData), len(todos))
+		})
+	})
+
+	t.Run("update todo with quoted name", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, err := update(fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), []byte(`{"name":"it's 'quoted'"}`))
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			todos := getTodo(ts)
+			assert.Equal(t, "it's 'quoted'", todos[0]["name"])
+		})
+	})
+
 	t.Run("update todo without anything json data cause no effect", func(t *testing.T) {
 		runTest(func(ts *httptest.Server) {
 			resp, err := update(fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), make([]byte, 0))
@@ -497,45 +427,1846 @@ func TestUpdateTodo(t *testing.T) {
 	})
 }
 
-func TestLogin(t *testing.T) {
-	login := func(user string, passwd string, baseURL string) (*http.Response, error) {
-		message, err := json.Marshal(map[string]string{
-			"username": user,
-			"password": passwd,
+func TestTrash(t *testing.T) {
+	send := func(method string, url string) *http.Response {
+		req, err := http.NewRequest(method, url, bytes.NewBuffer(make([]byte, 0)))
+		if err != nil {
+			panic(err)
+		}
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+
+		return resp
+	}
+
+	getTrash := func(ts *httptest.Server) []map[string]string {
+		resp := send(http.MethodGet, fmt.Sprintf("%v/trash", ts.URL))
+		defer resp.Body.Close()
+
+		var respData []map[string]string
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(respBytes, &respData); err != nil {
+			panic(err)
+		}
+
+		return respData
+	}
+
+	t.Run("deleted todo moves to trash", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodDelete, fmt.Sprintf("%v/todos?id=%v", ts.URL, 2))
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			trash := getTrash(ts)
+			assert.Equal(t, 1, len(trash))
+			assert.Equal(t, "2", trash[0]["id"])
+			assert.Equal(t, initDBData[1].Name, trash[0]["name"])
+			_, err := time.Parse(time.RFC3339, trash[0]["deleted_at"])
+			assert.Nil(t, err)
+		})
+	})
+
+	t.Run("restore todo from trash", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			send(http.MethodDelete, fmt.Sprintf("%v/todos?id=%v", ts.URL, 2))
+
+			resp := send(http.MethodPost, fmt.Sprintf("%v/trash/%v/restore", ts.URL, 2))
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			assert.Equal(t, 0, len(getTrash(ts)))
+			todos := getTodo(ts)
+			assert.Equal(t, len(initDBData), len(todos))
+			for i, todo := range todos {
+				assert.Equal(t, initDBData[i].Name, todo["name"])
+			}
+		})
+	})
+
+	t.Run("restore todo not in trash cause not found", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodPost, fmt.Sprintf("%v/trash/%v/restore", ts.URL, 1))
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("restore with invalid id cause error", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodPost, fmt.Sprintf("%v/trash/%v/restore", ts.URL, "abc"))
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 		})
+	})
+}
 
+func TestTodoHistory(t *testing.T) {
+	send := func(method string, url string, body []byte) *http.Response {
+		req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
 		if err != nil {
 			panic(err)
 		}
 
-		req, err := http.NewRequest(
-			http.MethodPost,
-			fmt.Sprintf("%v/login", baseURL),
-			bytes.NewReader(message),
-		)
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+
+		return resp
+	}
 
+	rename := func(ts *httptest.Server, id int, name string) {
+		body, _ := json.Marshal(map[string]string{"name": name})
+		send(http.MethodPatch, fmt.Sprintf("%v/todos?id=%v", ts.URL, id), body)
+	}
+
+	type revision struct {
+		Rev       int
+		Action    string
+		Actor     string
+		CreatedAt string `json:"created_at"`
+		Diff      map[string]struct {
+			Old any
+			New any
+		}
+	}
+
+	getHistory := func(ts *httptest.Server, id int) (int, []revision) {
+		resp := send(http.MethodGet, fmt.Sprintf("%v/todos/%v/history", ts.URL, id), nil)
+		defer resp.Body.Close()
+
+		var revisions []revision
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(respBytes, &revisions)
+
+		return resp.StatusCode, revisions
+	}
+
+	t.Run("update is recorded as revision", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			rename(ts, 1, "boil water")
+
+			status, revisions := getHistory(ts, 1)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, 1, len(revisions))
+			assert.Equal(t, 1, revisions[0].Rev)
+			assert.Equal(t, repository.RevisionUpdate, revisions[0].Action)
+			assert.Equal(t, anonymousActor, revisions[0].Actor)
+			assert.Equal(t, initDBData[0].Name, revisions[0].Diff["name"].Old)
+			assert.Equal(t, "boil water", revisions[0].Diff["name"].New)
+		})
+	})
+
+	t.Run("todo without change has empty history", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, revisions := getHistory(ts, 2)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, 0, len(revisions))
+		})
+	})
+
+	t.Run("history of unknown todo cause not found", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, _ := getHistory(ts, 10)
+			assert.Equal(t, http.StatusNotFound, status)
+		})
+	})
+
+	t.Run("revert to previous revision", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			rename(ts, 1, "boil water")
+			rename(ts, 1, "boil milk")
+			send(http.MethodDelete, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), nil)
+
+			resp := send(http.MethodPost, fmt.Sprintf("%v/todos/%v/revert?rev=%v", ts.URL, 1, 1), nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			todos := getTodo(ts)
+			assert.Equal(t, len(initDBData), len(todos))
+			assert.Equal(t, "boil water", todos[0]["name"])
+
+			_, revisions := getHistory(ts, 1)
+			assert.Equal(t, 4, len(revisions))
+			assert.Equal(t, repository.RevisionRevert, revisions[3].Action)
+			assert.Equal(t, "boil milk", revisions[3].Diff["name"].Old)
+			assert.Equal(t, "boil water", revisions[3].Diff["name"].New)
+			assert.Equal(t, true, revisions[3].Diff["deleted"].Old)
+			assert.Equal(t, false, revisions[3].Diff["deleted"].New)
+		})
+	})
+
+	t.Run("revert to unknown revision cause not found", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodPost, fmt.Sprintf("%v/todos/%v/revert?rev=%v", ts.URL, 1, 1), nil)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("revert with invalid revision cause error", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodPost, fmt.Sprintf("%v/todos/%v/revert?rev=%v", ts.URL, 1, "abc"), nil)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			resp = send(http.MethodPost, fmt.Sprintf("%v/todos/%v/revert", ts.URL, 1), nil)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	})
+}
+
+func TestConcurrencyControl(t *testing.T) {
+	send := func(method string, url string, ifMatch string, body []byte) *http.Response {
+		req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
 		if err != nil {
 			panic(err)
 		}
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
 
 		client := &http.Client{}
-		return client.Do(req)
+		resp, err := client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+
+		return resp
 	}
 
-	t.Run("login valid username and password returns session hash as cookie", func(t *testing.T) {
+	rename, _ := json.Marshal(map[string]string{"name": "boil water"})
+
+	t.Run("get todo returns etag", func(t *testing.T) {
 		runTest(func(ts *httptest.Server) {
-			resp, err := login("Taro", "Taro", ts.URL)
-			assert.Nil(t, err)
+			resp := send(http.MethodGet, fmt.Sprintf("%v/todos/%v", ts.URL, 1), "", nil)
 			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
 
-			cookieMap := make(map[string]string)
-			for _, cookie := range resp.Cookies() {
-				cookieMap[cookie.Name] = cookie.Value
-			}
+			resp = send(http.MethodGet, fmt.Sprintf("%v/todos/%v", ts.URL, 10), "", nil)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
 
-			t.Log(cookieMap["SessionHash"])
-			assert.Equal(t, "Taro", cookieMap["Username"])
-			assert.Equal(t, 64, len(cookieMap["SessionHash"]))
+	t.Run("update with matched etag", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodPatch, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), `"1"`, rename)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+			assert.Equal(t, "boil water", getTodo(ts)[0]["name"])
+		})
+	})
+
+	t.Run("update with stale etag cause precondition failed", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			send(http.MethodPatch, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), "", rename)
+
+			other, _ := json.Marshal(map[string]string{"name": "boil milk"})
+			resp := send(http.MethodPatch, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), `"1"`, other)
+			assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+			assert.Equal(t, "boil water", getTodo(ts)[0]["name"])
+		})
+	})
+
+	t.Run("delete with etag", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodDelete, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), `"2", W/"1"`, nil)
+			assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+			assert.Equal(t, len(initDBData), len(getTodo(ts)))
+
+			resp = send(http.MethodDelete, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), `"2", "1"`, nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, len(initDBData)-1, len(getTodo(ts)))
+		})
+	})
+
+	t.Run("wildcard matches existing todo only", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodPatch, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), "*", rename)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			resp = send(http.MethodDelete, fmt.Sprintf("%v/todos?id=%v", ts.URL, 10), "*", nil)
+			assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+		})
+	})
+
+	t.Run("strict mode requires if-match", func(t *testing.T) {
+		router := setupMock()
+		router.SetStrictPrecondition(true)
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		resp := send(http.MethodPatch, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), "", rename)
+		assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+
+		resp = send(http.MethodDelete, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), "", nil)
+		assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+
+		resp = send(http.MethodPatch, fmt.Sprintf("%v/todos?id=%v", ts.URL, 1), `"1"`, rename)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestConditionalGet(t *testing.T) {
+	get := func(url string, header map[string]string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			panic(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+
+		return resp
+	}
+
+	for _, path := range []string{"/todos", "/todos/1"} {
+		t.Run(fmt.Sprintf("%v returns validators", path), func(t *testing.T) {
+			runTest(func(ts *httptest.Server) {
+				resp := get(ts.URL+path, nil)
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.NotEmpty(t, resp.Header.Get("ETag"))
+				assert.Equal(t, mockCreatedAt.Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+			})
+		})
+
+		t.Run(fmt.Sprintf("%v with matched If-None-Match is not modified", path), func(t *testing.T) {
+			runTest(func(ts *httptest.Server) {
+				etag := get(ts.URL+path, nil).Header.Get("ETag")
+
+				resp := get(ts.URL+path, map[string]string{"If-None-Match": etag})
+				assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+				assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+				body, _ := ioutil.ReadAll(resp.Body)
+				assert.Empty(t, body)
+			})
+		})
+
+		t.Run(fmt.Sprintf("%v with If-Modified-Since", path), func(t *testing.T) {
+			runTest(func(ts *httptest.Server) {
+				resp := get(ts.URL+path, map[string]string{
+					"If-Modified-Since": mockCreatedAt.Format(http.TimeFormat),
+				})
+				assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+				resp = get(ts.URL+path, map[string]string{
+					"If-Modified-Since": mockCreatedAt.Add(-time.Second).Format(http.TimeFormat),
+				})
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			})
 		})
+
+		t.Run(fmt.Sprintf("%v is modified after update", path), func(t *testing.T) {
+			runTest(func(ts *httptest.Server) {
+				etag := get(ts.URL+path, nil).Header.Get("ETag")
+
+				body, _ := json.Marshal(map[string]string{"name": "boil water"})
+				req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%v/todos?id=1", ts.URL), bytes.NewBuffer(body))
+				(&http.Client{}).Do(req)
+
+				resp := get(ts.URL+path, map[string]string{
+					"If-None-Match":     etag,
+					"If-Modified-Since": time.Now().Add(time.Hour).Format(http.TimeFormat),
+				})
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+			})
+		})
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	send := func(url string, key string, todo map[string]string, cookies ...*http.Cookie) *http.Response {
+		body, err := json.Marshal(todo)
+		if err != nil {
+			panic(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("Idempotency-Key", key)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+
+		return resp
+	}
+	post := func(ts *httptest.Server, key string, todo map[string]string, cookies ...*http.Cookie) *http.Response {
+		return send(ts.URL+"/todos", key, todo, cookies...)
+	}
+
+	todo := map[string]string{"id": "4", "name": "new todo task"}
+
+	t.Run("retry with same key is replayed", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			first := post(ts, "key-1", todo)
+			assert.Equal(t, http.StatusOK, first.StatusCode)
+			assert.Empty(t, first.Header.Get("Idempotent-Replayed"))
+			firstBody, _ := ioutil.ReadAll(first.Body)
+
+			second := post(ts, "key-1", todo)
+			assert.Equal(t, http.StatusOK, second.StatusCode)
+			assert.Equal(t, "true", second.Header.Get("Idempotent-Replayed"))
+			secondBody, _ := ioutil.ReadAll(second.Body)
+			assert.Equal(t, firstBody, secondBody)
+
+			assert.Equal(t, len(initDBData)+1, len(getTodo(ts)))
+		})
+	})
+
+	t.Run("different keys create different todos", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			post(ts, "key-1", todo)
+			post(ts, "key-2", todo)
+
+			assert.Equal(t, len(initDBData)+2, len(getTodo(ts)))
+		})
+	})
+
+	t.Run("error response is replayed too", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			invalid := map[string]string{"id": "4"}
+
+			assert.Equal(t, http.StatusBadRequest, post(ts, "key-1", invalid).StatusCode)
+			assert.Equal(t, http.StatusBadRequest, post(ts, "key-1", invalid).StatusCode)
+		})
+	})
+
+	t.Run("reuse key with different payload cause unprocessable entity", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			post(ts, "key-1", todo)
+
+			resp := post(ts, "key-1", map[string]string{"id": "4", "name": "another todo task"})
+			assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+			assert.Equal(t, len(initDBData)+1, len(getTodo(ts)))
+		})
+	})
+
+	t.Run("concurrent requests with same key create one todo", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					post(ts, "key-1", todo)
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, len(initDBData)+1, len(getTodo(ts)))
+		})
+	})
+
+	t.Run("keys of different actors do not collide", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			body, _ := json.Marshal(map[string]string{"username": "Taro", "password": "Taro"})
+			resp, err := http.Post(ts.URL+"/login", "application/json", bytes.NewReader(body))
+			assert.Nil(t, err)
+			resp.Body.Close()
+
+			post(ts, "key-1", todo)
+			resp = post(ts, "key-1", map[string]string{"id": "4", "name": "another todo task"}, resp.Cookies()...)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get("Idempotent-Replayed"))
+
+			assert.Equal(t, len(initDBData)+2, len(getTodo(ts)))
+		})
+	})
+
+	t.Run("headers of the response are replayed", func(t *testing.T) {
+		router := setupMock()
+		router.engine.POST("/created", router.idempotent, func(c *gin.Context) {
+			c.Header("Location", "/todos/4")
+			c.Header("ETag", `"1"`)
+			c.Header("X-Not-Replayed", "1")
+			c.JSON(http.StatusCreated, map[string]string{"id": "4"})
+		})
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		first := send(ts.URL+"/created", "key-1", todo)
+		second := send(ts.URL+"/created", "key-1", todo)
+		assert.Equal(t, http.StatusCreated, second.StatusCode)
+		assert.Equal(t, "true", second.Header.Get("Idempotent-Replayed"))
+		for _, name := range []string{"Location", "ETag", "Content-Type"} {
+			assert.Equal(t, first.Header.Get(name), second.Header.Get(name), name)
+		}
+		assert.Empty(t, second.Header.Get("X-Not-Replayed"))
+	})
+
+	t.Run("key handled by another server is rejected until it completes", func(t *testing.T) {
+		repo := memory.NewRepository()
+		repo.Restore(seed)
+
+		started, release := make(chan struct{}), make(chan struct{})
+		servers := []*httptest.Server{}
+		for i := 0; i < 2; i++ {
+			router := NewRouter(gin.Default(), repo)
+			router.engine.POST("/slow", router.idempotent, func(c *gin.Context) {
+				close(started)
+				<-release
+				c.JSON(http.StatusOK, map[string]string{})
+			})
+			ts := httptest.NewServer(router.engine)
+			defer ts.Close()
+			servers = append(servers, ts)
+		}
+
+		done := make(chan int)
+		go func() {
+			resp := send(servers[0].URL+"/slow", "key-1", todo)
+			resp.Body.Close()
+			done <- resp.StatusCode
+		}()
+		<-started
+
+		assert.Equal(t, http.StatusConflict, send(servers[1].URL+"/slow", "key-1", todo).StatusCode)
+
+		close(release)
+		assert.Equal(t, http.StatusOK, <-done)
+
+		resp := send(servers[1].URL+"/slow", "key-1", todo)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+	})
+
+	t.Run("key is released when the handler fails", func(t *testing.T) {
+		router := setupMock()
+		router.SetLogger(nil)
+		calls := 0
+		router.engine.POST("/flaky", router.idempotent, func(c *gin.Context) {
+			calls++
+			switch calls {
+			case 1:
+				c.JSON(http.StatusServiceUnavailable, map[string]string{})
+			case 2:
+				panic("boom")
+			default:
+				c.JSON(http.StatusOK, map[string]string{})
+			}
+		})
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, send(ts.URL+"/flaky", "key-1", todo).StatusCode)
+		assert.Equal(t, http.StatusInternalServerError, send(ts.URL+"/flaky", "key-1", todo).StatusCode)
+
+		resp := send(ts.URL+"/flaky", "key-1", todo)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Idempotent-Replayed"))
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("key is released and saved after the client has gone away", func(t *testing.T) {
+		router := setupMock()
+		router.SetLogger(nil)
+		calls := 0
+		cancelRequest := func(c *gin.Context) {
+			ctx, cancel := context.WithCancel(c.Request.Context())
+			c.Request = c.Request.WithContext(ctx)
+			c.Set("cancel", cancel)
+		}
+		router.engine.POST("/gone", cancelRequest, router.idempotent, func(c *gin.Context) {
+			calls++
+			c.MustGet("cancel").(context.CancelFunc)()
+			if calls == 1 {
+				c.JSON(http.StatusServiceUnavailable, map[string]string{})
+				return
+			}
+			c.JSON(http.StatusOK, map[string]string{})
+		})
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, send(ts.URL+"/gone", "key-1", todo).StatusCode)
+
+		resp := send(ts.URL+"/gone", "key-1", todo)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Idempotent-Replayed"))
+
+		resp = send(ts.URL+"/gone", "key-1", todo)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+		assert.Equal(t, 2, calls)
+	})
+}
+
+func TestBatchTodo(t *testing.T) {
+	type result struct {
+		Status int
+		Error  string
+	}
+
+	batch := func(ts *httptest.Server, body any) (int, []result) {
+		reqBody, err := json.Marshal(body)
+		if err != nil {
+			panic(err)
+		}
+
+		resp, err := http.Post(fmt.Sprintf("%v/todos:batch", ts.URL), "application/json", bytes.NewBuffer(reqBody))
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+
+		var respData struct {
+			Results []result
+		}
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(respBytes, &respData)
+
+		return resp.StatusCode, respData.Results
+	}
+
+	t.Run("atomic batch applies every operation", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, results := batch(ts, map[string]any{
+				"operations": []map[string]any{
+					{"op": "create", "name": "wash the bowl"},
+					{"op": "update", "id": 1, "name": "boil water"},
+					{"op": "delete", "id": 2},
+				},
+			})
+
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, []result{{Status: 200}, {Status: 200}, {Status: 200}}, results)
+
+			todos := getTodo(ts)
+			assert.Equal(t, 3, len(todos))
+			assert.Equal(t, "boil water", todos[0]["name"])
+			assert.Equal(t, initDBData[2].Name, todos[1]["name"])
+			assert.Equal(t, "wash the bowl", todos[2]["name"])
+		})
+	})
+
+	t.Run("atomic batch is rolled back by failed operation", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, results := batch(ts, map[string]any{
+				"mode": "atomic",
+				"operations": []map[string]any{
+					{"op": "update", "id": 1, "name": "boil water"},
+					{"op": "delete", "id": 2, "version": 5},
+				},
+			})
+
+			assert.Equal(t, http.StatusPreconditionFailed, status)
+			assert.Equal(t, http.StatusFailedDependency, results[0].Status)
+			assert.Equal(t, http.StatusPreconditionFailed, results[1].Status)
+
+			todos := getTodo(ts)
+			assert.Equal(t, len(initDBData), len(todos))
+			for i, todo := range todos {
+				assert.Equal(t, initDBData[i].Name, todo["name"])
+			}
+		})
+	})
+
+	t.Run("atomic batch with invalid operation is not applied", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, results := batch(ts, map[string]any{
+				"operations": []map[string]any{
+					{"op": "delete", "id": 1},
+					{"op": "create"},
+				},
+			})
+
+			assert.Equal(t, http.StatusBadRequest, status)
+			assert.Equal(t, http.StatusFailedDependency, results[0].Status)
+			assert.Equal(t, http.StatusBadRequest, results[1].Status)
+			assert.Equal(t, len(initDBData), len(getTodo(ts)))
+		})
+	})
+
+	t.Run("best effort batch reports each status", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, results := batch(ts, map[string]any{
+				"mode": "best_effort",
+				"operations": []map[string]any{
+					{"op": "update", "id": 1, "name": "boil water"},
+					{"op": "delete", "id": 2, "version": 5},
+					{"op": "rename", "id": 3},
+					{"op": "delete", "id": 3},
+				},
+			})
+
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, http.StatusOK, results[0].Status)
+			assert.Equal(t, http.StatusPreconditionFailed, results[1].Status)
+			assert.Equal(t, "Precondition Failed", results[1].Error)
+			assert.Equal(t, http.StatusBadRequest, results[2].Status)
+			assert.Equal(t, http.StatusOK, results[3].Status)
+
+			todos := getTodo(ts)
+			assert.Equal(t, 2, len(todos))
+			assert.Equal(t, "boil water", todos[0]["name"])
+			assert.Equal(t, initDBData[1].Name, todos[1]["name"])
+		})
+	})
+
+	t.Run("too large batch is rejected", func(t *testing.T) {
+		router := setupMock()
+		router.SetMaxBatchSize(2)
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		status, _ := batch(ts, map[string]any{
+			"operations": []map[string]any{
+				{"op": "delete", "id": 1},
+				{"op": "delete", "id": 2},
+				{"op": "delete", "id": 3},
+			},
+		})
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+		assert.Equal(t, len(initDBData), len(getTodo(ts)))
+	})
+
+	t.Run("invalid batch request cause error", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, _ := batch(ts, map[string]any{"operations": []map[string]any{}})
+			assert.Equal(t, http.StatusBadRequest, status)
+
+			status, _ = batch(ts, map[string]any{
+				"mode":       "sometimes",
+				"operations": []map[string]any{{"op": "delete", "id": 1}},
+			})
+			assert.Equal(t, http.StatusBadRequest, status)
+
+			resp, err := http.Post(fmt.Sprintf("%v/todos:other", ts.URL), "application/json", nil)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+}
+
+func TestTodoDescription(t *testing.T) {
+	send := func(method string, url string, body map[string]string) *http.Response {
+		reqBody, _ := json.Marshal(body)
+		req, err := http.NewRequest(method, url, bytes.NewBuffer(reqBody))
+		if err != nil {
+			panic(err)
+		}
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+
+		return resp
+	}
+
+	t.Run("post todo with description", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodPost, fmt.Sprintf("%v/todos", ts.URL), map[string]string{
+				"id":          "4",
+				"name":        "wash the bowl",
+				"description": "with hot water",
+			})
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			todos := getTodo(ts)
+			assert.Equal(t, "with hot water", todos[3]["description"])
+			assert.Equal(t, "", todos[0]["description"])
+		})
+	})
+
+	t.Run("update only description", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp := send(http.MethodPatch, fmt.Sprintf("%v/todos?id=1", ts.URL), map[string]string{
+				"description": "use the kettle",
+			})
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			todos := getTodo(ts)
+			assert.Equal(t, initDBData[0].Name, todos[0]["name"])
+			assert.Equal(t, "use the kettle", todos[0]["description"])
+		})
+	})
+}
+
+func TestReplaceAndMergePatchTodo(t *testing.T) {
+	send := func(method string, url string, contentType string, body string) (*http.Response, map[string]string) {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			panic(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+
+		var respData map[string]string
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(respBytes, &respData)
+
+		return resp, respData
+	}
+
+	setDescription := func(ts *httptest.Server) {
+		send(http.MethodPatch, fmt.Sprintf("%v/todos/1", ts.URL), "", `{"description":"use the kettle"}`)
+	}
+
+	t.Run("put replaces every field", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			setDescription(ts)
+
+			resp, todo := send(http.MethodPut, fmt.Sprintf("%v/todos/1", ts.URL), "application/json", `{"id":"1","name":"boil water"}`)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.NotEmpty(t, resp.Header.Get("ETag"))
+			assert.Equal(t, map[string]string{"id": "1", "name": "boil water", "description": ""}, todo)
+
+			todos := getTodo(ts)
+			assert.Equal(t, "boil water", todos[0]["name"])
+			assert.Equal(t, "", todos[0]["description"])
+		})
+	})
+
+	t.Run("merge patch keeps absent fields and clears null", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			setDescription(ts)
+
+			resp, todo := send(http.MethodPatch, fmt.Sprintf("%v/todos/1", ts.URL), "application/merge-patch+json", `{"name":"boil water"}`)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "boil water", todo["name"])
+			assert.Equal(t, "use the kettle", todo["description"])
+
+			resp, todo = send(http.MethodPatch, fmt.Sprintf("%v/todos/1", ts.URL), "application/merge-patch+json", `{"description":null}`)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "boil water", todo["name"])
+			assert.Equal(t, "", todo["description"])
+		})
+	})
+
+	t.Run("unknown todo cause not found", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, _ := send(http.MethodPut, fmt.Sprintf("%v/todos/10", ts.URL), "", `{"name":"boil water"}`)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+			resp, _ = send(http.MethodPatch, fmt.Sprintf("%v/todos/10", ts.URL), "", `{"name":"boil water"}`)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("malformed body cause error", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			bodies := map[string][]string{
+				http.MethodPut: {
+					`{"name":`,
+					`["boil water"]`,
+					`{"description":"no name"}`,
+					`{"name":null}`,
+					`{"name":1}`,
+					`{"name":"boil water","done":true}`,
+					`{"id":"2","name":"boil water"}`,
+				},
+				http.MethodPatch: {
+					`{"name":`,
+					`"boil water"`,
+					`{"name":null}`,
+					`{"description":["kettle"]}`,
+					`{"done":true}`,
+				},
+			}
+
+			for method, cases := range bodies {
+				for _, body := range cases {
+					resp, _ := send(method, fmt.Sprintf("%v/todos/1", ts.URL), "", body)
+					assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "%v %v", method, body)
+				}
+			}
+
+			todos := getTodo(ts)
+			assert.Equal(t, initDBData[0].Name, todos[0]["name"])
+		})
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, _ := send(http.MethodPatch, fmt.Sprintf("%v/todos/1", ts.URL), "text/plain", `{"name":"boil water"}`)
+			assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+			resp, _ = send(http.MethodPut, fmt.Sprintf("%v/todos/1", ts.URL), "application/merge-patch+json", `{"name":"boil water"}`)
+			assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+		})
+	})
+
+	t.Run("put honours if-match", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("%v/todos/1", ts.URL), bytes.NewBufferString(`{"name":"boil water"}`))
+			req.Header.Set("If-Match", `"5"`)
+			resp, err := (&http.Client{}).Do(req)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+		})
+	})
+
+	t.Run("legacy patch rejects malformed body", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, _ := send(http.MethodPatch, fmt.Sprintf("%v/todos?id=1", ts.URL), "", `{"name":`)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	})
+}
+
+func TestJSONPatchTodo(t *testing.T) {
+	send := func(url string, body string, header map[string]string) (*http.Response, map[string]string) {
+		req, err := http.NewRequest(http.MethodPatch, url, bytes.NewBufferString(body))
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := (&http.Client{}).Do(req)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+
+		var respData map[string]string
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(respBytes, &respData)
+
+		return resp, respData
+	}
+
+	t.Run("apply operations in order", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			patch := `[
+				{"op":"test","path":"/name","value":"` + initDBData[0].Name + `"},
+				{"op":"copy","from":"/name","path":"/description"},
+				{"op":"replace","path":"/name","value":"boil water"}
+			]`
+			resp, todo := send(fmt.Sprintf("%v/todos/1", ts.URL), patch, nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.NotEmpty(t, resp.Header.Get("ETag"))
+			assert.Equal(t, map[string]string{"id": "1", "name": "boil water", "description": initDBData[0].Name}, todo)
+
+			resp, todo = send(fmt.Sprintf("%v/todos/1", ts.URL), `[{"op":"move","from":"/description","path":"/name"}]`, nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, map[string]string{"id": "1", "name": initDBData[0].Name, "description": ""}, todo)
+		})
+	})
+
+	t.Run("failed test rejects whole patch", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			patch := `[
+				{"op":"replace","path":"/name","value":"boil water"},
+				{"op":"test","path":"/description","value":"use the kettle"}
+			]`
+			resp, _ := send(fmt.Sprintf("%v/todos/1", ts.URL), patch, nil)
+			assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+			todos := getTodo(ts)
+			assert.Equal(t, initDBData[0].Name, todos[0]["name"])
+		})
+	})
+
+	t.Run("missing path cause conflict", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, _ := send(fmt.Sprintf("%v/todos/1", ts.URL), `[{"op":"replace","path":"/done","value":true}]`, nil)
+			assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		})
+	})
+
+	t.Run("invalid result cause unprocessable entity", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			patches := []string{
+				`[{"op":"remove","path":"/name"}]`,
+				`[{"op":"replace","path":"/name","value":1}]`,
+				`[{"op":"replace","path":"/id","value":"2"}]`,
+				`[{"op":"add","path":"/done","value":true}]`,
+				`[{"op":"replace","path":"","value":["boil water"]}]`,
+			}
+
+			for _, patch := range patches {
+				resp, _ := send(fmt.Sprintf("%v/todos/1", ts.URL), patch, nil)
+				assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, patch)
+			}
+
+			todos := getTodo(ts)
+			assert.Equal(t, initDBData[0].Name, todos[0]["name"])
+		})
+	})
+
+	t.Run("malformed patch cause bad request", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			patches := []string{
+				`[{"op":"replace"`,
+				`{"op":"replace","path":"/name","value":"boil water"}`,
+				`[{"op":"increment","path":"/name"}]`,
+				`[{"op":"replace","path":"/name"}]`,
+				`[{"op":"copy","path":"/name"}]`,
+				`[{"op":"remove","path":"name"}]`,
+			}
+
+			for _, patch := range patches {
+				resp, _ := send(fmt.Sprintf("%v/todos/1", ts.URL), patch, nil)
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode, patch)
+			}
+		})
+	})
+
+	t.Run("unknown todo cause not found", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, _ := send(fmt.Sprintf("%v/todos/10", ts.URL), `[{"op":"replace","path":"/name","value":"boil water"}]`, nil)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("honours if-match", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			patch := `[{"op":"replace","path":"/name","value":"boil water"}]`
+
+			resp, _ := send(fmt.Sprintf("%v/todos/1", ts.URL), patch, map[string]string{"If-Match": `"5"`})
+			assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+			resp, _ = send(fmt.Sprintf("%v/todos/1", ts.URL), patch, map[string]string{"If-Match": `"1"`})
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+		})
+	})
+}
+
+func TestSearchTodo(t *testing.T) {
+	type result struct {
+		Id          string
+		Name        string
+		Description string
+		Score       float64
+		Highlight   struct {
+			Name        string
+			Description string
+		}
+	}
+
+	type searchResponse struct {
+		Total   int
+		Page    int
+		PerPage int `json:"per_page"`
+		Results []result
+	}
+
+	search := func(ts *httptest.Server, query string) (int, searchResponse) {
+		resp, err := http.Get(fmt.Sprintf("%v/todos/search?%v", ts.URL, query))
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+
+		var respData searchResponse
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(respBytes, &respData)
+
+		return resp.StatusCode, respData
+	}
+
+	t.Run("search todos by word", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, resp := search(ts, "q=Ramen")
+
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, 1, resp.Total)
+			assert.Equal(t, 1, resp.Page)
+			assert.Equal(t, 20, resp.PerPage)
+			assert.Equal(t, 1, len(resp.Results))
+			assert.Equal(t, "3", resp.Results[0].Id)
+			assert.Equal(t, "eat <mark>ramen</mark>", resp.Results[0].Highlight.Name)
+			assert.Greater(t, resp.Results[0].Score, 0.0)
+		})
+	})
+
+	t.Run("highlight escapes the todo", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, err := http.Post(fmt.Sprintf("%v/todos", ts.URL), "application/json", strings.NewReader(`{"id":"4","name":"<b>noodles</b> & <i>soup</i>"}`))
+			assert.Nil(t, err)
+			resp.Body.Close()
+
+			status, result := search(ts, "q=noodles")
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, 1, len(result.Results))
+			assert.Equal(t, "<b>noodles</b> & <i>soup</i>", result.Results[0].Name)
+			assert.Equal(t, "&lt;b&gt;<mark>noodles</mark>&lt;/b&gt; &amp; &lt;i&gt;soup&lt;/i&gt;", result.Results[0].Highlight.Name)
+		})
+	})
+
+	t.Run("search results are paginated", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, resp := search(ts, "q=water+ramen+minutes&per_page=2&page=2")
+
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, 3, resp.Total)
+			assert.Equal(t, 2, resp.Page)
+			assert.Equal(t, 1, len(resp.Results))
+			assert.Equal(t, "3", resp.Results[0].Id)
+
+			_, resp = search(ts, "q=water&page=5")
+			assert.Equal(t, 1, resp.Total)
+			assert.Equal(t, 0, len(resp.Results))
+		})
+	})
+
+	t.Run("page whose offset overflows cause error", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, _ := search(ts, "q=ramen&page=9223372036854775807")
+			assert.Equal(t, http.StatusBadRequest, status)
+
+			maxPage := math.MaxInt/defaultSearchPerPage + 1
+			status, _ = search(ts, fmt.Sprintf("q=ramen&page=%v", maxPage+1))
+			assert.Equal(t, http.StatusBadRequest, status)
+
+			status, result := search(ts, fmt.Sprintf("q=ramen&page=%v", maxPage))
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, 0, len(result.Results))
+		})
+	})
+
+	t.Run("invalid search cause error", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			for _, query := range []string{"", "q=", "q=the", "q=water&page=0", "q=water&per_page=abc"} {
+				status, _ := search(ts, query)
+				assert.Equal(t, http.StatusBadRequest, status, query)
+			}
+		})
+	})
+}
+
+func TestProblemDetails(t *testing.T) {
+	type problem struct {
+		Type      string        `json:"type"`
+		Title     string        `json:"title"`
+		Status    int           `json:"status"`
+		Detail    string        `json:"detail"`
+		Instance  string        `json:"instance"`
+		RequestID string        `json:"request_id"`
+		Errors    []fieldError  `json:"errors"`
+		Results   []batchResult `json:"results"`
+	}
+
+	send := func(method string, url string, body string) (*http.Response, problem) {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			panic(err)
+		}
+
+		resp, err := (&http.Client{}).Do(req)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+
+		var p problem
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(respBytes, &p)
+
+		return resp, p
+	}
+
+	t.Run("error response is problem", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, p := send(http.MethodGet, fmt.Sprintf("%v/todos/10", ts.URL), "")
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+			assert.Equal(t, "about:blank", p.Type)
+			assert.Equal(t, "Not Found", p.Title)
+			assert.Equal(t, http.StatusNotFound, p.Status)
+			assert.Equal(t, "/todos/10", p.Instance)
+			assert.NotEmpty(t, p.RequestID)
+			assert.Equal(t, resp.Header.Get("X-Request-ID"), p.RequestID)
+		})
+	})
+
+	t.Run("every request has its own id", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			_, first := send(http.MethodGet, fmt.Sprintf("%v/todos/10", ts.URL), "")
+			_, second := send(http.MethodGet, fmt.Sprintf("%v/todos/10", ts.URL), "")
+			assert.NotEqual(t, first.RequestID, second.RequestID)
+
+			resp, err := http.Get(fmt.Sprintf("%v/todos", ts.URL))
+			assert.Nil(t, err)
+			assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+		})
+	})
+
+	t.Run("validation error has field errors", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, p := send(http.MethodPut, fmt.Sprintf("%v/todos/1", ts.URL), `{"description":"no name"}`)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.Equal(t, []fieldError{{Field: "name", Message: "is required"}}, p.Errors)
+
+			resp, p = send(http.MethodGet, fmt.Sprintf("%v/todos/search?q=water&page=0", ts.URL), "")
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.Equal(t, "page", p.Errors[0].Field)
+		})
+	})
+
+	t.Run("unknown route is problem", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			for _, url := range []string{"/unknown", "/todos:unknown"} {
+				resp, p := send(http.MethodPost, ts.URL+url, "")
+				assert.Equal(t, http.StatusNotFound, resp.StatusCode, url)
+				assert.Equal(t, http.StatusNotFound, p.Status, url)
+			}
+		})
+	})
+
+	t.Run("failed batch is problem with results", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			body := `{"operations":[{"op":"delete","id":1},{"op":"delete","id":2,"version":5}]}`
+			resp, p := send(http.MethodPost, fmt.Sprintf("%v/todos:batch", ts.URL), body)
+			assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+			assert.Equal(t, http.StatusPreconditionFailed, p.Status)
+			assert.Equal(t, []batchResult{
+				{Status: http.StatusFailedDependency, Error: "Failed Dependency"},
+				{Status: http.StatusPreconditionFailed, Error: "Precondition Failed"},
+			}, p.Results)
+		})
+	})
+
+	t.Run("repository error does not expose its cause", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/todos", nil)
+
+		cause := errors.New("Error 1406: Data too long for column 'title' at row 1")
+		p := newProblem(c, http.StatusBadRequest, &repository.Error{Kind: repository.ErrInvalid, Err: cause})
+		assert.Equal(t, "invalid argument", p.Detail)
+
+		p = newProblem(c, http.StatusPreconditionFailed, fmt.Errorf("todo 1: %w", repository.ErrVersionMismatch))
+		assert.Equal(t, "version mismatch", p.Detail)
+
+		p = newProblem(c, http.StatusBadRequest, invalidField("name", "is required"))
+		assert.Equal(t, "name: is required", p.Detail)
+	})
+
+	t.Run("failed login is problem", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, p := send(http.MethodPost, fmt.Sprintf("%v/login", ts.URL), `{"username":"Taro","password":"wrong"}`)
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+			assert.Equal(t, http.StatusUnauthorized, p.Status)
+		})
+	})
+}
+
+func TestRequestValidation(t *testing.T) {
+	type problem struct {
+		Status  int           `json:"status"`
+		Errors  []fieldError  `json:"errors"`
+		Results []batchResult `json:"results"`
+	}
+
+	send := func(method string, url string, body string) (*http.Response, problem) {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			panic(err)
+		}
+
+		resp, err := (&http.Client{}).Do(req)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+
+		var p problem
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(respBytes, &p)
+
+		return resp, p
+	}
+
+	longName := strings.Repeat("a", 129)
+	longDescription := strings.Repeat("a", 1025)
+
+	t.Run("invalid fields of todo are reported", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			cases := []struct {
+				method   string
+				url      string
+				body     string
+				expected []fieldError
+			}{
+				{http.MethodPost, "/todos", `{"id":"4"}`, []fieldError{{Field: "name", Message: "is required"}}},
+				{http.MethodPost, "/todos", `{"id":"4","name":"  "}`, []fieldError{{Field: "name", Message: "must not be blank"}}},
+				{http.MethodPost, "/todos", `{"id":"x","name":"` + longName + `"}`, []fieldError{
+					{Field: "id", Message: "must be a number"},
+					{Field: "name", Message: "must be at most 128 characters"},
+				}},
+				{http.MethodPost, "/todos", `{"id":"4","name":"tea","description":"` + longDescription + `"}`, []fieldError{
+					{Field: "description", Message: "must be at most 1024 characters"},
+				}},
+				{http.MethodPost, "/todos", `{"id":4,"name":"tea"}`, []fieldError{{Field: "id", Message: "must be string"}}},
+				{http.MethodPut, "/todos/1", `{"name":"` + longName + `"}`, []fieldError{{Field: "name", Message: "must be at most 128 characters"}}},
+				{http.MethodPatch, "/todos/1", `{"name":"\t"}`, []fieldError{{Field: "name", Message: "must not be blank"}}},
+				{http.MethodPatch, "/todos?id=1", `{"description":"` + longDescription + `"}`, []fieldError{
+					{Field: "description", Message: "must be at most 1024 characters"},
+				}},
+				{http.MethodPost, "/login", `{"password":"Taro"}`, []fieldError{{Field: "username", Message: "is required"}}},
+				{http.MethodPost, "/todos:batch", `{"mode":"eventually","operations":[]}`, []fieldError{
+					{Field: "mode", Message: "must be one of atomic, best_effort"},
+					{Field: "operations", Message: "must have at least 1 items"},
+				}},
+			}
+
+			for _, c := range cases {
+				resp, p := send(c.method, ts.URL+c.url, c.body)
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "%v %v", c.method, c.url)
+				assert.Equal(t, c.expected, p.Errors, "%v %v", c.method, c.url)
+			}
+
+			todos := getTodo(ts)
+			assert.Equal(t, len(initDBData), len(todos))
+			assert.Equal(t, initDBData[0].Name, todos[0]["name"])
+		})
+	})
+
+	t.Run("json patch resulting in invalid todo", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%v/todos/1", ts.URL), bytes.NewBufferString(`[{"op":"replace","path":"/name","value":""}]`))
+			req.Header.Set("Content-Type", "application/json-patch+json")
+			resp, err := (&http.Client{}).Do(req)
+			assert.Nil(t, err)
+			defer resp.Body.Close()
+
+			var p problem
+			json.NewDecoder(resp.Body).Decode(&p)
+			assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+			assert.Equal(t, []fieldError{{Field: "name", Message: "must not be blank"}}, p.Errors)
+		})
+	})
+
+	t.Run("invalid operation of batch is reported", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			body := `{"mode":"best_effort","operations":[{"op":"create","name":" "},{"op":"update","name":"tea"},{"op":"delete","id":1}]}`
+			resp, p := send(http.MethodPost, fmt.Sprintf("%v/todos:batch", ts.URL), body)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, []batchResult{
+				{Status: http.StatusBadRequest, Error: "Bad Request", Errors: []fieldError{{Field: "name", Message: "must not be blank"}}},
+				{Status: http.StatusBadRequest, Error: "Bad Request", Errors: []fieldError{{Field: "id", Message: "is required for update"}}},
+				{Status: http.StatusOK},
+			}, p.Results)
+		})
+	})
+
+	t.Run("date range", func(t *testing.T) {
+		type request struct {
+			Due  string    `json:"due" binding:"omitempty,daterange=2022-01-01~2022-12-31"`
+			From time.Time `json:"from" binding:"daterange=2022-04-01~"`
+		}
+		from := time.Date(2022, 4, 1, 23, 0, 0, 0, time.UTC)
+
+		assert.Nil(t, validate(&request{Due: "2022-01-01", From: from}))
+		assert.Nil(t, validate(&request{Due: "2022-12-31", From: from.AddDate(1, 0, 0)}))
+
+		err := validate(&request{Due: "2023-01-01", From: from.AddDate(0, 0, -1)})
+		var verr *validationError
+		assert.ErrorAs(t, err, &verr)
+		assert.Equal(t, []fieldError{
+			{Field: "due", Message: "must be a date in 2022-01-01~2022-12-31"},
+			{Field: "from", Message: "must be a date in 2022-04-01~"},
+		}, verr.fields)
+
+		assert.Error(t, validate(&request{Due: "01/01/2022", From: from}))
+	})
+}
+
+func TestErrorStatus(t *testing.T) {
+	cases := map[error]int{
+		repository.ErrNotFound:                           http.StatusNotFound,
+		repository.ErrConflict:                           http.StatusConflict,
+		repository.ErrVersionMismatch:                    http.StatusPreconditionFailed,
+		repository.ErrInvalid:                            http.StatusBadRequest,
+		repository.ErrUnavailable:                        http.StatusServiceUnavailable,
+		repository.ErrTimeout:                            http.StatusGatewayTimeout,
+		errAborted:                                       http.StatusFailedDependency,
+		errPreconditionRequired:                          http.StatusPreconditionRequired,
+		fmt.Errorf("todo 1: %w", repository.ErrNotFound): http.StatusNotFound,
+		fmt.Errorf("unknown"):                            http.StatusInternalServerError,
+		invalidField("name", "is required"):              http.StatusBadRequest,
+		&kindError{kind: errUnprocessable, err: invalidField("name", "is required")}: http.StatusUnprocessableEntity,
+	}
+
+	for err, status := range cases {
+		assert.Equal(t, status, errorStatus(err), "%v", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	login := func(user string, passwd string, baseURL string) (*http.Response, error) {
+		message, err := json.Marshal(map[string]string{
+			"username": user,
+			"password": passwd,
+		})
+
+		if err != nil {
+			panic(err)
+		}
+
+		req, err := http.NewRequest(
+			http.MethodPost,
+			fmt.Sprintf("%v/login", baseURL),
+			bytes.NewReader(message),
+		)
+
+		if err != nil {
+			panic(err)
+		}
+
+		client := &http.Client{}
+		return client.Do(req)
+	}
+
+	t.Run("login valid username and password returns session hash as cookie", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			resp, err := login("Taro", "Taro", ts.URL)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			cookieMap := make(map[string]string)
+			for _, cookie := range resp.Cookies() {
+				cookieMap[cookie.Name] = cookie.Value
+			}
+
+			t.Log(cookieMap["SessionHash"])
+			assert.Equal(t, "Taro", cookieMap["Username"])
+			assert.Equal(t, 64, len(cookieMap["SessionHash"]))
+		})
+	})
+
+	t.Run("session cookies have the configured attributes", func(t *testing.T) {
+		router := setupMock()
+		router.SetCookieOptions(CookieOptions{MaxAge: time.Hour, Path: "/todos", Domain: "example.com", Secure: true})
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		resp, err := login("Taro", "Taro", ts.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		assert.Equal(t, 2, len(resp.Cookies()))
+		for _, cookie := range resp.Cookies() {
+			assert.Equal(t, 3600, cookie.MaxAge)
+			assert.Equal(t, "/todos", cookie.Path)
+			assert.Equal(t, "example.com", cookie.Domain)
+			assert.True(t, cookie.Secure)
+			assert.True(t, cookie.HttpOnly)
+		}
+	})
+}
+
+func TestServe(t *testing.T) {
+	// serve starts a server whose /slow blocks until release is closed,
+	// and sends a request to /slow which reports its status to inFlight.
+	serve := func(drain time.Duration) (url string, release chan struct{}, inFlight chan int, cancel context.CancelFunc, served chan error) {
+		router := setupMock()
+		started := make(chan struct{})
+		release = make(chan struct{})
+		router.engine.GET("/slow", func(c *gin.Context) {
+			close(started)
+			<-release
+			c.String(http.StatusOK, "done")
+		})
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+		url = fmt.Sprintf("http://%v", listener.Addr())
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		served = make(chan error, 1)
+		go func() {
+			served <- router.Serve(ctx, listener, drain)
+		}()
+
+		inFlight = make(chan int, 1)
+		go func() {
+			resp, err := http.Get(url + "/slow")
+			if err != nil {
+				inFlight <- 0
+				return
+			}
+			resp.Body.Close()
+			inFlight <- resp.StatusCode
+		}()
+		<-started
+
+		return url, release, inFlight, cancel, served
+	}
+
+	t.Run("requests in flight complete and new connections are refused", func(t *testing.T) {
+		url, release, inFlight, cancel, served := serve(time.Minute)
+		cancel()
+
+		select {
+		case err := <-served:
+			t.Fatalf("server stopped with a request in flight: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		_, err := http.Get(url + "/")
+		assert.Error(t, err)
+
+		close(release)
+		assert.Equal(t, http.StatusOK, <-inFlight)
+		assert.Nil(t, <-served)
+	})
+
+	t.Run("readiness fails during the grace before connections are refused", func(t *testing.T) {
+		router := setupMock()
+		router.SetReadinessGrace(500 * time.Millisecond)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+		url := fmt.Sprintf("http://%v", listener.Addr())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() {
+			served <- router.Serve(ctx, listener, time.Minute)
+		}()
+
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		resp, err := client.Get(url + "/readyz")
+		assert.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		start := time.Now()
+		cancel()
+		assert.Eventually(t, func() bool {
+			resp, err := client.Get(url + "/readyz")
+			if err != nil {
+				return false
+			}
+			resp.Body.Close()
+			return resp.StatusCode == http.StatusServiceUnavailable
+		}, time.Second, 10*time.Millisecond)
+
+		resp, err = client.Get(url + "/")
+		assert.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		assert.Nil(t, <-served)
+		assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+
+		_, err = client.Get(url + "/")
+		assert.Error(t, err)
+	})
+
+	t.Run("requests exceeding the drain timeout are cut", func(t *testing.T) {
+		_, release, inFlight, cancel, served := serve(50 * time.Millisecond)
+		defer close(release)
+
+		cancel()
+		assert.ErrorIs(t, <-served, context.DeadlineExceeded)
+		assert.Equal(t, 0, <-inFlight)
+	})
+}
+
+func TestHealth(t *testing.T) {
+	get := func(ts *httptest.Server, path string) (int, readinessResponse) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+
+		var body readinessResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+
+		return resp.StatusCode, body
+	}
+
+	t.Run("liveness does not depend on anything", func(t *testing.T) {
+		router := setupMock()
+		router.AddReadinessCheck("database", func(ctx context.Context) error { return errors.New("down") })
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		status, body := get(ts, "/healthz")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "ok", body.Status)
+	})
+
+	t.Run("readiness reports every check", func(t *testing.T) {
+		router := setupMock()
+		router.SetReadinessTimeout(50 * time.Millisecond)
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		status, body := get(ts, "/readyz")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "ok", body.Status)
+
+		router.AddReadinessCheck("database", func(ctx context.Context) error { return nil })
+		status, body = get(ts, "/readyz")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, checkStatus{Status: "ok"}, body.Checks["database"])
+
+		router.AddReadinessCheck("migrations", func(ctx context.Context) error { return repository.ErrUnavailable })
+		router.AddReadinessCheck("cache", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		status, body = get(ts, "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "unavailable", body.Status)
+		assert.Equal(t, "ok", body.Checks["database"].Status)
+		assert.Equal(t, checkStatus{Status: "failing"}, body.Checks["migrations"])
+		assert.Equal(t, checkStatus{Status: "failing"}, body.Checks["cache"])
+	})
+
+	t.Run("errors of the checks are logged instead of answered", func(t *testing.T) {
+		var logs lockedBuffer
+		router := setupMock()
+		router.SetLogger(logging.New(&logs, logging.LevelInfo))
+		router.AddReadinessCheck("database", func(ctx context.Context) error {
+			return errors.New("dial tcp 10.0.0.5:3306: connect: connection refused")
+		})
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		resp, err := http.Get(ts.URL + "/readyz")
+		assert.Nil(t, err)
+		raw, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.NotContains(t, string(raw), "10.0.0.5")
+
+		var body readinessResponse
+		json.Unmarshal(raw, &body)
+		assert.Equal(t, checkStatus{Status: "failing"}, body.Checks["database"])
+
+		assert.Eventually(t, func() bool {
+			for _, entry := range logs.entries(body.RequestID) {
+				if entry["msg"] == "readiness check failed" {
+					return entry["check"] == "database" && strings.Contains(entry["error"].(string), "10.0.0.5")
+				}
+			}
+			return false
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("readiness fails while shutting down", func(t *testing.T) {
+		router := setupMock()
+		router.AddReadinessCheck("database", func(ctx context.Context) error { return nil })
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() {
+			served <- router.Serve(ctx, listener, time.Second)
+		}()
+
+		cancel()
+		assert.Nil(t, <-served)
+
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		status, body := get(ts, "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, errShuttingDown.Error(), body.Error)
+		assert.Empty(t, body.Checks)
+
+		status, _ = get(ts, "/healthz")
+		assert.Equal(t, http.StatusOK, status)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	scrape := func(ts *httptest.Server) (int, string) {
+		resp, err := http.Get(ts.URL + "/metrics")
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return resp.StatusCode, string(body)
+	}
+
+	t.Run("metrics are not served unless set", func(t *testing.T) {
+		runTest(func(ts *httptest.Server) {
+			status, _ := scrape(ts)
+			assert.Equal(t, http.StatusNotFound, status)
+		})
+	})
+
+	t.Run("requests and logins are counted", func(t *testing.T) {
+		router := setupMock()
+		router.SetMetrics(metrics.NewRegistry())
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		for _, path := range []string{"/todos/1", "/todos/2", "/todos/100", "/unknown"} {
+			resp, err := http.Get(ts.URL + path)
+			assert.Nil(t, err)
+			resp.Body.Close()
+		}
+		for _, password := range []string{"Taro", "wrong"} {
+			resp, err := http.Post(ts.URL+"/login", "application/json", strings.NewReader(`{"username":"Taro","password":"`+password+`"}`))
+			assert.Nil(t, err)
+			resp.Body.Close()
+		}
+		resp, err := http.Post(ts.URL+"/login", "application/json", strings.NewReader(`{"username":"Nobody","password":"Nobody"}`))
+		assert.Nil(t, err)
+		resp.Body.Close()
+
+		status, body := scrape(ts)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, `http_requests_total{method="GET",route="/todos/:id",status="2xx"} 2`)
+		assert.Contains(t, body, `http_requests_total{method="GET",route="/todos/:id",status="4xx"} 1`)
+		assert.Contains(t, body, `http_requests_total{method="GET",route="unmatched",status="4xx"} 1`)
+		assert.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/todos/:id"} 3`)
+		assert.Contains(t, body, `http_request_duration_seconds_bucket{method="POST",route="/login",le="+Inf"} 3`)
+		assert.Contains(t, body, `login_attempts_total{result="success"} 1`)
+		assert.Contains(t, body, `login_attempts_total{result="failure"} 2`)
+	})
+
+	t.Run("panicked requests are counted", func(t *testing.T) {
+		router := setupMock()
+		router.SetLogger(nil)
+		router.SetMetrics(metrics.NewRegistry())
+		router.engine.GET("/panic", func(c *gin.Context) {
+			panic("boom")
+		})
+		ts := httptest.NewServer(router.engine)
+		defer ts.Close()
+
+		resp, err := http.Get(ts.URL + "/panic")
+		assert.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+		_, body := scrape(ts)
+		assert.Contains(t, body, `http_requests_total{method="GET",route="/panic",status="5xx"} 1`)
+		assert.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/panic"} 1`)
+	})
+}
+
+// lockedBuffer collects log lines written while the server is running.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// entries returns the log lines having the request ID.
+func (b *lockedBuffer) entries(id string) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var entry map[string]interface{}
+		if json.Unmarshal([]byte(line), &entry) == nil && entry["request_id"] == id {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+func TestRequestLogging(t *testing.T) {
+	var logs lockedBuffer
+	router := setupMock()
+	router.SetLogger(logging.New(&logs, logging.LevelInfo))
+	router.engine.GET("/panic", func(c *gin.Context) { panic("boom") })
+	ts := httptest.NewServer(router.engine)
+	defer ts.Close()
+
+	get := func(path string, id string) (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+
+		return resp, body
+	}
+
+	t.Run("id from the client is propagated to the response and the logs", func(t *testing.T) {
+		resp, body := get("/todos/10", "lb-1234.abcd")
+		assert.Equal(t, "lb-1234.abcd", resp.Header.Get("X-Request-ID"))
+		assert.Equal(t, "lb-1234.abcd", body["request_id"])
+
+		assert.Eventually(t, func() bool { return len(logs.entries("lb-1234.abcd")) == 2 }, time.Second, 10*time.Millisecond)
+		entries := logs.entries("lb-1234.abcd")
+		assert.Equal(t, "request rejected", entries[0]["msg"])
+		assert.Equal(t, "info", entries[0]["level"])
+		assert.Contains(t, entries[0]["error"], "not found")
+		assert.Equal(t, "request", entries[1]["msg"])
+		assert.Equal(t, "/todos/10", entries[1]["path"])
+		assert.Equal(t, float64(http.StatusNotFound), entries[1]["status"])
+	})
+
+	t.Run("invalid id is replaced", func(t *testing.T) {
+		for _, id := range []string{"has space", "quote\"", strings.Repeat("a", 129)} {
+			resp, _ := get("/todos", id)
+			generated := resp.Header.Get("X-Request-ID")
+			assert.NotEqual(t, id, generated)
+			_, err := uuid.Parse(generated)
+			assert.Nil(t, err)
+		}
+
+		resp, _ := get("/todos", "")
+		assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+	})
+
+	t.Run("panic is answered with problem and logged as error", func(t *testing.T) {
+		resp, body := get("/panic", "panicking")
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Equal(t, "panicking", body["request_id"])
+
+		assert.Eventually(t, func() bool { return len(logs.entries("panicking")) == 2 }, time.Second, 10*time.Millisecond)
+		entries := logs.entries("panicking")
+		assert.Equal(t, "error", entries[0]["level"])
+		assert.Equal(t, "panic: boom", entries[0]["error"])
+		assert.Contains(t, entries[0]["stack"], "TestRequestLogging")
+		assert.Equal(t, float64(http.StatusInternalServerError), entries[1]["status"])
 	})
 }
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchPerPage = 20
+	maxSearchPerPage     = 100
+)
+
+// getPositiveQuery returns the query parameter as a positive number,
+// or defaultValue when the parameter is absent.
+func getPositiveQuery(c *gin.Context, key string, defaultValue int) (int, error) {
+	value, ok := c.GetQuery(key)
+	if !ok {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return -1, invalidField(key, "must be a positive number: %v", value)
+	}
+
+	return n, nil
+}
+
+func (r *Router) searchTodo(c *gin.Context) {
+	query := c.Query("q")
+	if len(repository.Tokenize(query)) == 0 {
+		errorHandling(invalidField("q", "has no searchable word: %v", query), c)
+		return
+	}
+
+	page, err := getPositiveQuery(c, "page", 1)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	perPage, err := getPositiveQuery(c, "per_page", defaultSearchPerPage)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+	if perPage > maxSearchPerPage {
+		perPage = maxSearchPerPage
+	}
+
+	// The offset of the page must not overflow.
+	if maxPage := math.MaxInt/perPage + 1; page > maxPage {
+		errorHandling(invalidField("page", "must be at most %v", maxPage), c)
+		return
+	}
+
+	result, err := r.repo.SearchTodos(c.Request.Context(), query, (page-1)*perPage, perPage)
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	todos := []gin.H{}
+	for _, res := range result.Results {
+		todos = append(todos, gin.H{
+			"id":          strconv.Itoa(res.Todo.Id),
+			"name":        res.Todo.Name,
+			"description": res.Todo.Description,
+			"score":       res.Score,
+			"highlight": gin.H{
+				"name":        repository.Highlight(res.Todo.Name, query),
+				"description": repository.Highlight(res.Todo.Description, query),
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":    result.Total,
+		"page":     page,
+		"per_page": perPage,
+		"results":  todos,
+	})
+}
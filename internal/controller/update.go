@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mimeJSON       = "application/json"
+	mimeMergePatch = "application/merge-patch+json"
+	mimeJSONPatch  = "application/json-patch+json"
+)
+
+// todoField binds a member of the todo JSON representation to its TodoUpdater field.
+// A required field can be neither omitted by PUT nor cleared by null.
+type todoField struct {
+	name     string
+	required bool
+	field    func(u *repository.TodoUpdater) *repository.Updatable[string]
+}
+
+var todoFields = []todoField{
+	{
+		name:     "name",
+		required: true,
+		field:    func(u *repository.TodoUpdater) *repository.Updatable[string] { return &u.Name },
+	},
+	{
+		name:     "description",
+		required: false,
+		field:    func(u *repository.TodoUpdater) *repository.Updatable[string] { return &u.Description },
+	},
+}
+
+// todoValues are the values a write sets to a todo.
+// Nil fields are left unchanged and are not validated.
+type todoValues struct {
+	Name        *string `json:"name" binding:"omitempty,notblank,max=128"`
+	Description *string `json:"description" binding:"omitempty,max=1024"`
+}
+
+// validateUpdater checks the values set by the updater.
+func validateUpdater(todo repository.TodoUpdater) error {
+	var values todoValues
+	if todo.Name.Updatable {
+		values.Name = &todo.Name.Value
+	}
+	if todo.Description.Updatable {
+		values.Description = &todo.Description.Value
+	}
+
+	return validate(&values)
+}
+
+// decodeTodoObject decodes body into the members of a todo.
+// "id" may be sent back as it is returned by GET, but must match the todo.
+func decodeTodoObject(body []byte, id int) (map[string]json.RawMessage, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, err
+	}
+	if members == nil {
+		return nil, fmt.Errorf("body must be a JSON object")
+	}
+
+	if raw, ok := members["id"]; ok {
+		var idString string
+		if err := json.Unmarshal(raw, &idString); err != nil || idString != strconv.Itoa(id) {
+			return nil, invalidField("id", "does not match the todo: %s", raw)
+		}
+		delete(members, "id")
+	}
+
+	known := map[string]bool{}
+	for _, f := range todoFields {
+		known[f.name] = true
+	}
+	for name := range members {
+		if !known[name] {
+			return nil, invalidField(name, "is unknown")
+		}
+	}
+
+	return members, nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// decodeMergePatch converts a JSON Merge Patch (RFC 7396) into a TodoUpdater.
+// Absent members are left unchanged and null clears the field.
+func decodeMergePatch(body []byte, id int) (repository.TodoUpdater, error) {
+	todo := repository.TodoUpdater{Id: id}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return todo, nil
+	}
+
+	members, err := decodeTodoObject(body, id)
+	if err != nil {
+		return todo, err
+	}
+
+	for _, f := range todoFields {
+		raw, ok := members[f.name]
+		if !ok {
+			continue
+		}
+
+		field := f.field(&todo)
+		field.Updatable = true
+		if isNull(raw) {
+			if f.required {
+				return todo, invalidField(f.name, "cannot be cleared")
+			}
+
+			continue
+		}
+
+		if err := json.Unmarshal(raw, &field.Value); err != nil {
+			return todo, invalidField(f.name, "must be a string")
+		}
+	}
+
+	return todo, validateUpdater(todo)
+}
+
+// decodeReplacement converts a full representation sent by PUT into a TodoUpdater
+// which sets every field. Absent optional fields are cleared.
+func decodeReplacement(body []byte, id int) (repository.TodoUpdater, error) {
+	todo := repository.TodoUpdater{Id: id}
+
+	members, err := decodeTodoObject(body, id)
+	if err != nil {
+		return todo, err
+	}
+
+	for _, f := range todoFields {
+		raw, ok := members[f.name]
+		if f.required && (!ok || isNull(raw)) {
+			return todo, invalidField(f.name, "is required")
+		}
+
+		field := f.field(&todo)
+		field.Updatable = true
+		if !ok || isNull(raw) {
+			continue
+		}
+
+		if err := json.Unmarshal(raw, &field.Value); err != nil {
+			return todo, invalidField(f.name, "must be a string")
+		}
+	}
+
+	return todo, validateUpdater(todo)
+}
+
+// mediaType returns the media type of the request without parameters.
+// Requests without Content-Type are regarded as JSON.
+func mediaType(c *gin.Context) string {
+	header := c.GetHeader("Content-Type")
+	if header == "" {
+		return mimeJSON
+	}
+
+	media, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return header
+	}
+
+	return media
+}
+
+// writeTodo applies the updater to an existing todo and responds with the result.
+func (r *Router) writeTodo(c *gin.Context, id uint, decode func(body []byte, id int) (repository.TodoUpdater, error)) {
+	version, err := r.expectedVersion(c, id)
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	bodyBytes, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	todo, err := decode(bodyBytes, int(id))
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+	todo.Version = version
+
+	updated, err := r.updateAndGet(c, id, todo)
+	if err != nil {
+		failureHandling(err, c)
+		return
+	}
+
+	respondTodo(c, updated)
+}
+
+// updateAndGet applies the updater and reads the todo back in one unit of work,
+// so the result is exactly what the update produced.
+func (r *Router) updateAndGet(c *gin.Context, id uint, todo repository.TodoUpdater) (*repository.TodoResponse, error) {
+	ctx := c.Request.Context()
+	actor := r.actor(c)
+
+	var updated *repository.TodoResponse
+	err := r.repo.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+		if err := repo.UpdateTodo(ctx, int(id), todo, actor); err != nil {
+			return err
+		}
+
+		var err error
+		updated, err = repo.GetTodo(ctx, id)
+		return err
+	})
+
+	return updated, err
+}
+
+// respondTodo responds with the representation of the todo and its ETag.
+func respondTodo(c *gin.Context, todo *repository.TodoResponse) {
+	c.Header("ETag", formatETag(todo.Version))
+	c.JSON(http.StatusOK, map[string]string{
+		"id":          strconv.Itoa(todo.Id),
+		"name":        todo.Name,
+		"description": todo.Description,
+	})
+}
+
+func (r *Router) putTodo(c *gin.Context) {
+	id, err := getParamID(c)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	if mediaType(c) != mimeJSON {
+		failureHandling(fmt.Errorf("%w: %v", errUnsupportedMediaType, mediaType(c)), c)
+		return
+	}
+
+	r.writeTodo(c, id, decodeReplacement)
+}
+
+func (r *Router) patchTodo(c *gin.Context) {
+	id, err := getParamID(c)
+	if err != nil {
+		errorHandling(err, c)
+		return
+	}
+
+	switch mediaType(c) {
+	case mimeJSON, mimeMergePatch:
+		r.writeTodo(c, id, decodeMergePatch)
+	case mimeJSONPatch:
+		r.jsonPatchTodo(c, id)
+	default:
+		failureHandling(fmt.Errorf("%w: %v", errUnsupportedMediaType, mediaType(c)), c)
+	}
+}
@@ -0,0 +1,376 @@
+// Package jsonpatch applies JSON Patch documents (RFC 6902)
+// to JSON values decoded by encoding/json.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPatch is returned when the patch document itself is malformed.
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// ErrConflict is returned when the patch cannot be applied to the document,
+// e.g. the path does not exist.
+var ErrConflict = errors.New("patch conflicts with the document")
+
+// ErrTestFailed is returned when a test operation does not match.
+var ErrTestFailed = errors.New("test operation failed")
+
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+
+	path  []string
+	from  []string
+	value any
+}
+
+// UnmarshalJSON decodes an operation object. "path" and "from" are
+// distinguished between absent members and the empty pointer.
+func (op *Operation) UnmarshalJSON(data []byte) error {
+	var members struct {
+		Op    string          `json:"op"`
+		Path  *string         `json:"path"`
+		From  *string         `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+
+	if members.Path == nil {
+		return errors.New("operation requires path")
+	}
+	op.Op = members.Op
+	op.Path = *members.Path
+	op.Value = members.Value
+
+	if members.From != nil {
+		op.From = *members.From
+	} else if members.Op == "move" || members.Op == "copy" {
+		return fmt.Errorf("%v requires from", members.Op)
+	}
+
+	return nil
+}
+
+type Patch []Operation
+
+// Decode parses and validates a patch document.
+func Decode(body []byte) (Patch, error) {
+	var patch Patch
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	if patch == nil {
+		return nil, fmt.Errorf("%w: patch must be an array", ErrInvalidPatch)
+	}
+
+	for i := range patch {
+		if err := patch[i].validate(); err != nil {
+			return nil, fmt.Errorf("%w: operation %v: %v", ErrInvalidPatch, i, err)
+		}
+	}
+
+	return patch, nil
+}
+
+func (op *Operation) validate() error {
+	var err error
+
+	if op.path, err = parsePointer(op.Path); err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("%v requires value", op.Op)
+		}
+		if err := json.Unmarshal(op.Value, &op.value); err != nil {
+			return err
+		}
+	case "move", "copy":
+		if op.from, err = parsePointer(op.From); err != nil {
+			return err
+		}
+		if op.Op == "move" && isPrefix(op.from, op.path) && len(op.from) < len(op.path) {
+			return fmt.Errorf("cannot move %v into its child %v", op.From, op.Path)
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown operation: %v", op.Op)
+	}
+
+	return nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("pointer must start with /: %v", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func isPrefix(prefix []string, tokens []string) bool {
+	if len(prefix) > len(tokens) {
+		return false
+	}
+
+	for i := range prefix {
+		if prefix[i] != tokens[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Apply applies every operation in order and returns the patched document.
+// The document is not modified. When an operation fails, the whole patch
+// fails and no partial result is returned.
+func (p Patch) Apply(doc any) (any, error) {
+	doc = deepCopy(doc)
+
+	for i, op := range p {
+		var err error
+		if doc, err = op.apply(doc); err != nil {
+			return nil, fmt.Errorf("operation %v: %w", i, err)
+		}
+	}
+
+	return doc, nil
+}
+
+func (op Operation) apply(doc any) (any, error) {
+	switch op.Op {
+	case "add":
+		return add(doc, op.path, deepCopy(op.value))
+	case "remove":
+		doc, _, err := remove(doc, op.path)
+		return doc, err
+	case "replace":
+		if _, err := get(doc, op.path); err != nil {
+			return nil, err
+		}
+		if len(op.path) == 0 {
+			return deepCopy(op.value), nil
+		}
+
+		doc, _, err := remove(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, deepCopy(op.value))
+	case "move":
+		doc, value, err := remove(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, value)
+	case "copy":
+		value, err := get(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, deepCopy(value))
+	case "test":
+		value, err := get(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(value, op.value) {
+			return nil, fmt.Errorf("%w: %v", ErrTestFailed, op.Path)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operation: %v", ErrInvalidPatch, op.Op)
+	}
+}
+
+// arrayIndex parses an array index token. "-" is accepted only when
+// allowEnd is set and refers to the position after the last element.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return -1, fmt.Errorf("%w: invalid array index: %v", ErrConflict, token)
+	}
+
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if idx > max {
+		return -1, fmt.Errorf("%w: array index out of range: %v", ErrConflict, token)
+	}
+
+	return idx, nil
+}
+
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			child, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member not found: %v", ErrConflict, token)
+			}
+			doc = child
+		case []any:
+			idx, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[idx]
+		default:
+			return nil, fmt.Errorf("%w: cannot refer %v in a scalar", ErrConflict, token)
+		}
+	}
+
+	return doc, nil
+}
+
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	token := path[0]
+	switch node := doc.(type) {
+	case map[string]any:
+		if len(path) == 1 {
+			node[token] = value
+			return node, nil
+		}
+
+		child, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: member not found: %v", ErrConflict, token)
+		}
+
+		child, err := add(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+
+		return node, nil
+	case []any:
+		if len(path) == 1 {
+			idx, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+
+			node = append(node, nil)
+			copy(node[idx+1:], node[idx:])
+			node[idx] = value
+
+			return node, nil
+		}
+
+		idx, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+
+		child, err := add(node[idx], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[idx] = child
+
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%w: cannot add %v to a scalar", ErrConflict, token)
+	}
+}
+
+// remove deletes the value at path and returns the document and the removed value.
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("%w: cannot remove the whole document", ErrConflict)
+	}
+
+	token := path[0]
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: member not found: %v", ErrConflict, token)
+		}
+
+		if len(path) == 1 {
+			delete(node, token)
+			return node, child, nil
+		}
+
+		child, removed, err := remove(child, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[token] = child
+
+		return node, removed, nil
+	case []any:
+		idx, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(path) == 1 {
+			removed := node[idx]
+			return append(node[:idx], node[idx+1:]...), removed, nil
+		}
+
+		child, removed, err := remove(node[idx], path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[idx] = child
+
+		return node, removed, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: cannot remove %v from a scalar", ErrConflict, token)
+	}
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, child := range v {
+			m[key] = deepCopy(child)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, child := range v {
+			s[i] = deepCopy(child)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,89 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeJSON(s string) any {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+func TestApply(t *testing.T) {
+	type testCase struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+		err      error
+	}
+
+	cases := []testCase{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{"append array element", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"qux"}]`, `{"foo":["bar","qux"]}`, nil},
+		{"add to missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", ErrConflict},
+		{"add out of range", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"qux"}]`, "", ErrConflict},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{"remove array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"remove missing member", `{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, "", ErrConflict},
+		{"replace member", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{"replace missing member", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, "", ErrConflict},
+		{"replace whole document", `{"foo":"bar"}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`, nil},
+		{"move member", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil},
+		{"move array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{"copy member", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`, `{"foo":{"bar":1},"baz":{"bar":2}}`, nil},
+		{"test success", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{"test failure", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", ErrTestFailed},
+		{"test escaped pointer", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"test","path":"/~1","value":9}]`, `{"/":9,"~1":10}`, nil},
+		{"test leading zero index", `{"foo":["a","b"]}`, `[{"op":"test","path":"/foo/01","value":"b"}]`, "", ErrConflict},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			patch, err := Decode([]byte(c.patch))
+			assert.Nil(t, err)
+
+			doc := decodeJSON(c.doc)
+			actual, err := patch.Apply(doc)
+			if c.err != nil {
+				assert.True(t, errors.Is(err, c.err), "%v", err)
+				assert.Nil(t, actual)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, decodeJSON(c.expected), actual)
+			}
+
+			assert.Equal(t, decodeJSON(c.doc), doc, "original document must not be modified")
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	patches := []string{
+		`{"op":"add","path":"/foo","value":1}`,
+		`null`,
+		`[{"op":"increment","path":"/foo"}]`,
+		`[{"op":"add","path":"/foo"}]`,
+		`[{"op":"move","path":"/foo"}]`,
+		`[{"op":"copy","from":"/foo"}]`,
+		`[{"op":"remove","path":"foo"}]`,
+		`[{"op":"move","from":"/foo","path":"/foo/bar"}]`,
+	}
+
+	for _, patch := range patches {
+		_, err := Decode([]byte(patch))
+		assert.True(t, errors.Is(err, ErrInvalidPatch), patch)
+	}
+
+	_, err := Decode([]byte(`[{"op":"add","path":"/foo","value":null}]`))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,184 @@
+// Package logging writes leveled logs as one JSON object per line.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel returns the level named debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for level, n := range levelNames {
+		if n == name {
+			return level, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown log level: %q", name)
+}
+
+// output is the destination shared by a logger and the loggers made by its With,
+// so that their lines are not interleaved.
+type output struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+// Logger writes the entries at its level or above. It is safe for concurrent use,
+// and a nil Logger discards everything.
+type Logger struct {
+	out    *output
+	level  Level
+	fields []interface{}
+}
+
+func New(w io.Writer, level Level) *Logger {
+	l := new(Logger)
+	l.out = &output{w: w, now: time.Now}
+	l.level = level
+
+	return l
+}
+
+var defaultLogger = New(os.Stderr, LevelInfo)
+
+// Default returns the logger writing info and above to stderr,
+// which is used until another one is set.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// With returns a logger adding the key and value pairs to every entry.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	child := new(Logger)
+	child.out = l.out
+	child.level = l.level
+	child.fields = append(append([]interface{}(nil), l.fields...), keyvals...)
+
+	return child
+}
+
+// Enabled reports whether entries of level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var line bytes.Buffer
+	line.WriteByte('{')
+	writeField(&line, "time", l.out.now().UTC().Format(time.RFC3339Nano))
+	line.WriteByte(',')
+	writeField(&line, "level", level.String())
+	line.WriteByte(',')
+	writeField(&line, "msg", msg)
+
+	pairs := append(append([]interface{}(nil), l.fields...), keyvals...)
+	for i := 0; i < len(pairs); i += 2 {
+		key, value := fmt.Sprint(pairs[i]), interface{}(nil)
+		if i+1 < len(pairs) {
+			value = pairs[i+1]
+		} else {
+			key, value = "!BADKEY", pairs[i]
+		}
+
+		line.WriteByte(',')
+		writeField(&line, key, value)
+	}
+	line.WriteString("}\n")
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(line.Bytes())
+}
+
+func writeField(buf *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Time:
+		value = v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		value = v.String()
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(encoded)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying l, such as a logger with the ID of a request.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or fallback if there is none.
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+
+	return fallback
+}
@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLogger(level Level) (*Logger, *strings.Builder) {
+	var out strings.Builder
+	l := New(&out, level)
+	l.out.now = func() time.Time { return time.Date(2022, 4, 1, 9, 0, 0, 0, time.UTC) }
+
+	return l, &out
+}
+
+func TestLogger(t *testing.T) {
+	t.Run("entries are json lines", func(t *testing.T) {
+		l, out := newTestLogger(LevelInfo)
+		l.With("request_id", "5f0c").Error("request failed", "error", errors.New("boom"), "status", 500, "took", time.Second)
+
+		assert.Equal(t, `{"time":"2022-04-01T09:00:00Z","level":"error","msg":"request failed","request_id":"5f0c","error":"boom","status":500,"took":"1s"}`+"\n", out.String())
+	})
+
+	t.Run("entries below the level are dropped", func(t *testing.T) {
+		l, out := newTestLogger(LevelWarn)
+		l.Debug("debug")
+		l.Info("info")
+		l.Warn("warn")
+
+		assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+		assert.Contains(t, out.String(), `"level":"warn"`)
+		assert.True(t, l.Enabled(LevelError))
+		assert.False(t, l.Enabled(LevelInfo))
+	})
+
+	t.Run("with does not change the parent", func(t *testing.T) {
+		l, out := newTestLogger(LevelInfo)
+		child := l.With("request_id", "1")
+		child.With("user", "Taro").Info("child")
+		l.Info("parent")
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		assert.Contains(t, lines[0], `"request_id":"1","user":"Taro"`)
+		assert.NotContains(t, lines[1], "request_id")
+	})
+
+	t.Run("odd and unencodable values are kept", func(t *testing.T) {
+		l, out := newTestLogger(LevelInfo)
+		l.Info("odd", "key", func() {}, "alone")
+
+		var entry map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(out.String()), &entry))
+		assert.Equal(t, "alone", entry["!BADKEY"])
+		assert.NotEmpty(t, entry["key"])
+	})
+
+	t.Run("concurrent entries are not interleaved", func(t *testing.T) {
+		l, out := newTestLogger(LevelInfo)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				l.With("worker", i).Info(strings.Repeat("x", 1000))
+			}(i)
+		}
+		wg.Wait()
+
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			assert.True(t, json.Valid([]byte(line)))
+		}
+	})
+
+	t.Run("nil logger discards", func(t *testing.T) {
+		var l *Logger
+		l.With("key", "value").Error("dropped")
+		assert.False(t, l.Enabled(LevelError))
+	})
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		parsed, err := ParseLevel(level.String())
+		assert.Nil(t, err)
+		assert.Equal(t, level, parsed)
+	}
+
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestContext(t *testing.T) {
+	fallback, _ := newTestLogger(LevelInfo)
+	l := fallback.With("request_id", "1")
+
+	assert.Same(t, fallback, FromContext(context.Background(), fallback))
+	assert.Same(t, l, FromContext(NewContext(context.Background(), l), fallback))
+}
@@ -0,0 +1,24 @@
+package metrics
+
+import "database/sql"
+
+// RegisterDBStats registers the connection pool statistics of db,
+// which are read from db.Stats on every scrape.
+func RegisterDBStats(r *Registry, db *sql.DB) {
+	stat := func(f func(s sql.DBStats) float64) func() float64 {
+		return func() float64 { return f(db.Stats()) }
+	}
+
+	r.NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	r.NewGaugeFunc("db_open_connections", "Number of established connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	r.NewGaugeFunc("db_in_use_connections", "Number of connections currently in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	r.NewGaugeFunc("db_idle_connections", "Number of idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	r.NewCounterFunc("db_wait_count_total", "Number of times a connection was waited for.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	r.NewCounterFunc("db_wait_duration_seconds_total", "Total time spent waiting for a connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+}
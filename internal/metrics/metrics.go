@@ -0,0 +1,299 @@
+// Package metrics keeps counters and histograms of the server and
+// exposes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of histograms of latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// family is a metric with every series of its label values.
+type family interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry is the set of metrics served by ServeHTTP.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return new(Registry)
+}
+
+// register adds f, panicking when the name is taken
+// because it is a mistake in the code rather than at runtime.
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, registered := range r.families {
+		if registered.name() == f.name() {
+			panic(fmt.Sprintf("metric %v is registered twice", f.name()))
+		}
+	}
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	counter := &countingWriter{w: w}
+	buffered := bufio.NewWriter(counter)
+	for _, f := range families {
+		f.write(buffered)
+	}
+	err := buffered.Flush()
+
+	return counter.n, err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// metadata is the part common to every kind of metric.
+type metadata struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (m *metadata) name() string {
+	return m.metricName
+}
+
+func (m *metadata) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %v %v\n", m.metricName, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %v %v\n", m.metricName, m.kind)
+}
+
+// key joins label values into the key of their series.
+func (m *metadata) key(values []string) string {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %v has %v labels but %v values are given", m.metricName, len(m.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels formats the labels of a series, followed by extra
+// which is the le label of a histogram bucket.
+func (m *metadata) formatLabels(key string, extra ...string) string {
+	var pairs []string
+	if len(m.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%v="%v"`, m.labels[i], escapeLabel(value)))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, extra[i], escapeLabel(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec is a counter for each combination of label values.
+type CounterVec struct {
+	metadata
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec registers a counter partitioned by labels.
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := new(CounterVec)
+	c.metadata = metadata{metricName: name, help: help, kind: "counter", labels: labels}
+	c.values = map[string]float64{}
+
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter of the label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter of the label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%v%v %v\n", c.metricName, c.formatLabels(key), formatFloat(c.values[key]))
+	}
+}
+
+// HistogramVec is a histogram for each combination of label values.
+type HistogramVec struct {
+	metadata
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	// counts are the observations of each bucket, not cumulative.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a histogram partitioned by labels.
+// buckets are the upper bounds in increasing order, without +Inf.
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := new(HistogramVec)
+	h.metadata = metadata{metricName: name, help: help, kind: "histogram", labels: labels}
+	h.buckets = buckets
+	h.series = map[string]*histogram{}
+
+	r.register(h)
+	return h
+}
+
+// Observe adds v to the histogram of the label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%v_bucket%v %v\n", h.metricName, h.formatLabels(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", h.metricName, h.formatLabels(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.metricName, h.formatLabels(key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", h.metricName, h.formatLabels(key), s.count)
+	}
+}
+
+// funcMetric is a metric without labels whose value is read on every scrape.
+type funcMetric struct {
+	metadata
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by value.
+func (r *Registry) NewGaugeFunc(name string, help string, value func() float64) {
+	f := new(funcMetric)
+	f.metadata = metadata{metricName: name, help: help, kind: "gauge"}
+	f.value = value
+
+	r.register(f)
+}
+
+// NewCounterFunc registers a counter whose value is returned by value,
+// for counts kept by something else such as database/sql.
+func (r *Registry) NewCounterFunc(name string, help string, value func() float64) {
+	f := new(funcMetric)
+	f.metadata = metadata{metricName: name, help: help, kind: "counter"}
+	f.value = value
+
+	r.register(f)
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	fmt.Fprintf(w, "%v %v\n", f.metricName, formatFloat(f.value()))
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
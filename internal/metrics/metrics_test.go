@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(r *Registry) string {
+	var out strings.Builder
+	r.WriteTo(&out)
+
+	return out.String()
+}
+
+func TestRegistry(t *testing.T) {
+	t.Run("counters are written by label values", func(t *testing.T) {
+		r := NewRegistry()
+		c := r.NewCounterVec("logins_total", "Number of logins.", "result")
+		c.Inc("success")
+		c.Add(2, "failure")
+		c.Inc("success")
+
+		assert.Equal(t, `# HELP logins_total Number of logins.
+# TYPE logins_total counter
+logins_total{result="failure"} 2
+logins_total{result="success"} 2
+`, scrape(r))
+	})
+
+	t.Run("histogram buckets are cumulative", func(t *testing.T) {
+		r := NewRegistry()
+		h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+		h.Observe(0.05, "/todos")
+		h.Observe(0.1, "/todos")
+		h.Observe(0.5, "/todos")
+		h.Observe(3, "/todos")
+
+		assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/todos",le="0.1"} 2
+latency_seconds_bucket{route="/todos",le="1"} 3
+latency_seconds_bucket{route="/todos",le="+Inf"} 4
+latency_seconds_sum{route="/todos"} 3.65
+latency_seconds_count{route="/todos"} 4
+`, scrape(r))
+	})
+
+	t.Run("functions are read on every scrape", func(t *testing.T) {
+		r := NewRegistry()
+		value := 1.0
+		r.NewGaugeFunc("open", "Open.", func() float64 { return value })
+
+		assert.Contains(t, scrape(r), "# TYPE open gauge\nopen 1\n")
+		value = 2
+		assert.Contains(t, scrape(r), "open 2\n")
+	})
+
+	t.Run("label values and help are escaped", func(t *testing.T) {
+		r := NewRegistry()
+		r.NewCounterVec("escaped_total", "Line\nbreak.", "path").Inc("a\"b\\c\n")
+
+		assert.Equal(t, `# HELP escaped_total Line\nbreak.
+# TYPE escaped_total counter
+escaped_total{path="a\"b\\c\n"} 1
+`, scrape(r))
+	})
+
+	t.Run("misuse panics", func(t *testing.T) {
+		r := NewRegistry()
+		c := r.NewCounterVec("twice_total", "Twice.", "a")
+
+		assert.Panics(t, func() { r.NewCounterVec("twice_total", "Twice.") })
+		assert.Panics(t, func() { c.Inc("a", "b") })
+	})
+
+	t.Run("registry is served as text", func(t *testing.T) {
+		r := NewRegistry()
+		r.NewCounterVec("served_total", "Served.").Inc()
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Body.String(), "served_total 1\n")
+	})
+}
+
+func TestRegisterDBStats(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+
+	r := NewRegistry()
+	RegisterDBStats(r, db)
+	out := scrape(r)
+
+	assert.Contains(t, out, "db_max_open_connections 7\n")
+	assert.Contains(t, out, "# TYPE db_wait_count_total counter\n")
+	for _, name := range []string{"db_open_connections", "db_in_use_connections", "db_idle_connections", "db_wait_duration_seconds_total"} {
+		assert.Contains(t, out, "\n"+name+" ")
+	}
+}
@@ -0,0 +1,14 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/Soya-Onishi/api-server-go/internal/repository/repositorytest"
+)
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.TodoListManipulation {
+		return repository.NewTestRepository(t)
+	})
+}
@@ -1,31 +1,76 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"encoding/hex"
-	"fmt"
-	"log"
-	"net/http"
-	"os"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/logging"
 )
 
 type Repository struct {
-	db *sql.DB
+	db       *sql.DB
+	dialect  *dialect
+	timeouts Timeouts
+	logger   *logging.Logger
+	// tx is the transaction the repository is bound to by WithinTx,
+	// and depth is how deeply the unit of work is nested.
+	tx    querier
+	depth int
 }
 
+// TodoListManipulation is the storage of todos and users.
+// Every method returns an error wrapping one of ErrNotFound, ErrConflict,
+// ErrUnavailable, ErrInvalid or ErrTimeout on failure.
 type TodoListManipulation interface {
-	GetAllTodos() []TodoResponse
-	PostTodo(todo TodoResponse) int
-	DeleteTodo(id uint) int
-	UpdateTodo(id int, todo TodoUpdater) int
-	GetUserInfo(username string) (*UserInfo, int)
-	GetSessionHash(username string) (*[32]byte, int)
-	SetSessionHash(username string, hash [32]byte) int
+	GetAllTodos(ctx context.Context) ([]TodoResponse, error)
+	GetTodo(ctx context.Context, id uint) (*TodoResponse, error)
+	GetTodoListMetadata(ctx context.Context) (*TodoListMetadata, error)
+	SearchTodos(ctx context.Context, query string, offset int, limit int) (*SearchPage, error)
+	PostTodo(ctx context.Context, todo TodoResponse, actor string) error
+	DeleteTodo(ctx context.Context, id uint, version int, actor string) error
+	UpdateTodo(ctx context.Context, id int, todo TodoUpdater, actor string) error
+	GetTrash(ctx context.Context) ([]TrashedTodo, error)
+	RestoreTodo(ctx context.Context, id uint, actor string) error
+	GetTodoHistory(ctx context.Context, id uint) ([]TodoRevision, error)
+	RevertTodo(ctx context.Context, id uint, rev int, actor string) error
+	PurgeTrash(ctx context.Context, before time.Time) error
+	GetIdempotentResponse(ctx context.Context, actor string, key string, since time.Time) (*IdempotentResponse, error)
+	ReserveIdempotentResponse(ctx context.Context, resp IdempotentResponse, since time.Time) error
+	SaveIdempotentResponse(ctx context.Context, resp IdempotentResponse) error
+	ReleaseIdempotentResponse(ctx context.Context, actor string, key string) error
+	PurgeIdempotentResponses(ctx context.Context, before time.Time) error
+	GetUserInfo(ctx context.Context, username string) (*UserInfo, error)
+	GetSessionHash(ctx context.Context, username string) (*[32]byte, error)
+	SetSessionHash(ctx context.Context, username string, hash [32]byte) error
+	WithinTx(ctx context.Context, f func(repo TodoListManipulation) error) error
 }
 
 type TodoResponse struct {
-	Id   int
-	Name string
+	Id          int
+	Name        string
+	Description string
+	Version     int
+	UpdatedAt   time.Time
+}
+
+// TodoListMetadata summarizes the whole todo table so that a change
+// of any todo can be detected without reading the todos themselves.
+// Count and VersionSum can return to earlier values when todos are purged,
+// but MaxID grows with every created todo since ids are never reused.
+type TodoListMetadata struct {
+	Count        int
+	VersionSum   int64
+	MaxID        int
+	LastModified time.Time
+}
+
+type TrashedTodo struct {
+	Id          int
+	Name        string
+	Description string
+	DeletedAt   time.Time
 }
 
 type Updatable[T any] struct {
@@ -33,8 +78,12 @@ type Updatable[T any] struct {
 	Value     T
 }
 type TodoUpdater struct {
-	Id   int
-	Name Updatable[string]
+	Id          int
+	Name        Updatable[string]
+	Description Updatable[string]
+	// Version is the version the todo is expected to have.
+	// Zero skips the check.
+	Version int
 }
 
 type UserInfo struct {
@@ -45,48 +94,35 @@ type UserInfo struct {
 func NewRepository(db *sql.DB) *Repository {
 	r := new(Repository)
 	r.db = db
+	r.dialect = mysqlDialect
+	r.logger = logging.Default()
 
 	return r
 }
 
-func (r *Repository) beginTx(f func() error) int {
-	tx, err := r.db.Begin()
-	defer func() {
-		switch err {
-		case nil:
-			tx.Commit()
-		default:
-			tx.Rollback()
-		}
-	}()
-
-	if err != nil {
-		log.SetOutput(os.Stderr)
-		log.SetPrefix("[ERROR]")
-		log.Printf("%v", err)
-
-		return http.StatusInternalServerError
-	}
-
-	if err := f(); err != nil {
-		log.SetOutput(os.Stderr)
-		log.SetPrefix("[ERROR]")
-		log.Printf("%v", err)
+// SetLogger sets the logger used when ctx of an operation carries none.
+func (r *Repository) SetLogger(logger *logging.Logger) {
+	r.logger = logger
+}
 
-		return http.StatusInternalServerError
-	}
+// log returns the logger of ctx, which has the ID of the request.
+func (r *Repository) log(ctx context.Context) *logging.Logger {
+	return logging.FromContext(ctx, r.logger)
+}
 
-	return http.StatusOK
+// Close closes the database. It must not be called on a repository
+// bound to a transaction by WithinTx.
+func (r *Repository) Close() error {
+	return r.db.Close()
 }
 
-func (r *Repository) GetAllTodos() []TodoResponse {
-	rows, err := r.db.Query("SELECT * FROM todo_list ORDER BY id")
-	if err != nil {
-		log.SetOutput(os.Stderr)
-		log.SetPrefix("[ERROR]")
-		log.Printf("%v", err)
+func (r *Repository) GetAllTodos(ctx context.Context) ([]TodoResponse, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
 
-		return nil
+	rows, err := r.conn().QueryContext(ctx, "SELECT id, title, description, version, updated_at FROM todo.todo_list WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		return nil, classify(err)
 	}
 	defer rows.Close()
 
@@ -94,127 +130,281 @@ func (r *Repository) GetAllTodos() []TodoResponse {
 	for rows.Next() {
 		var id int
 		var name string
+		var description string
+		var version int
+		var updatedAt time.Time
 
-		rows.Scan(&id, &name)
+		if err := rows.Scan(&id, &name, &description, &version, &updatedAt); err != nil {
+			return nil, classify(err)
+		}
 
 		resp = append(resp, TodoResponse{
-			Id:   id,
-			Name: name,
+			Id:          id,
+			Name:        name,
+			Description: description,
+			Version:     version,
+			UpdatedAt:   updatedAt,
 		})
 	}
 
-	return resp
+	return resp, classify(rows.Err())
 }
 
-func (r *Repository) PostTodo(todo TodoResponse) int {
-	tx, err := r.db.Begin()
-	defer func() {
-		switch err {
-		case nil:
-			tx.Commit()
-		default:
-			tx.Rollback()
-		}
-	}()
+func (r *Repository) GetTodo(ctx context.Context, id uint) (*TodoResponse, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var todo TodoResponse
+	err := r.conn().QueryRowContext(
+		ctx,
+		"SELECT id, title, description, version, updated_at FROM todo.todo_list WHERE id = ? AND deleted_at IS NULL",
+		id,
+	).Scan(&todo.Id, &todo.Name, &todo.Description, &todo.Version, &todo.UpdatedAt)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, newError(ErrNotFound, "todo %v", id)
+	case err != nil:
+		return nil, classify(err)
+	}
 
-	if err != nil {
-		log.SetOutput(os.Stderr)
-		log.SetPrefix("[ERROR]")
-		log.Printf("%v", err)
+	return &todo, nil
+}
 
-		return http.StatusInternalServerError
-	}
+func (r *Repository) GetTodoListMetadata(ctx context.Context) (*TodoListMetadata, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
 
-	if _, err := tx.Exec("INSERT INTO todo.todo_list (title) VALUES(?)", todo.Name); err != nil {
-		log.SetOutput(os.Stderr)
-		log.SetPrefix("[ERROR]")
-		log.Printf("%v", err)
+	var metadata TodoListMetadata
+	var lastModified textTime
 
-		return http.StatusInternalServerError
+	err := r.conn().QueryRowContext(
+		ctx,
+		"SELECT COUNT(*), COALESCE(SUM(version), 0), COALESCE(MAX(id), 0), MAX(updated_at) FROM todo.todo_list",
+	).Scan(&metadata.Count, &metadata.VersionSum, &metadata.MaxID, &lastModified)
+	if err != nil {
+		return nil, classify(err)
 	}
 
-	return http.StatusOK
+	metadata.LastModified = lastModified.Time
+
+	return &metadata, nil
 }
 
-func (r *Repository) DeleteTodo(id uint) int {
-	tx, err := r.db.Begin()
-	defer func() {
-		switch err {
-		case nil:
-			tx.Commit()
-		default:
-			tx.Rollback()
-		}
-	}()
+func (r *Repository) PostTodo(ctx context.Context, todo TodoResponse, actor string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
 
+	return r.beginTx(ctx, func(tx querier) error {
+		return postTodo(ctx, tx, todo, actor)
+	})
+}
+
+func (r *Repository) DeleteTodo(ctx context.Context, id uint, version int, actor string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	return r.beginTx(ctx, func(tx querier) error {
+		return deleteTodo(ctx, tx, id, version, actor)
+	})
+}
+
+func (r *Repository) UpdateTodo(ctx context.Context, id int, todo TodoUpdater, actor string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	return r.beginTx(ctx, func(tx querier) error {
+		return updateTodo(ctx, tx, id, todo, actor)
+	})
+}
+
+func postTodo(ctx context.Context, tx querier, todo TodoResponse, actor string) error {
+	id, err := tx.InsertContext(
+		ctx,
+		"INSERT INTO todo.todo_list (title, description) VALUES(?, ?)",
+		todo.Name,
+		todo.Description,
+	)
 	if err != nil {
-		log.SetOutput(os.Stderr)
-		log.SetPrefix("[ERROR]")
-		log.Printf("%v", err)
+		return err
+	}
+
+	after := TodoSnapshot{Name: todo.Name, Description: todo.Description}
+	return recordRevision(ctx, tx, id, RevisionCreate, actor, nil, &after)
+}
 
-		return http.StatusInternalServerError
+func deleteTodo(ctx context.Context, tx querier, id uint, version int, actor string) error {
+	before, err := selectSnapshot(ctx, tx, int64(id))
+	if err != nil {
+		return err
 	}
+	if before == nil || before.Deleted {
+		if version != 0 {
+			return ErrVersionMismatch
+		}
 
-	if _, err := r.db.Exec("DELETE FROM todo.todo_list WHERE id = ?", id); err != nil {
-		log.SetOutput(os.Stderr)
-		log.SetPrefix("[ERROR]")
-		log.Printf("%v", err)
+		return nil
+	}
 
-		return http.StatusInternalServerError
+	query := newUpdateQuery("todo.todo_list").
+		setExpr("deleted_at = CURRENT_TIMESTAMP").
+		setExpr("version = version + 1").
+		where("id = ?", id)
+	if err := execVersioned(ctx, tx, query, version); err != nil {
+		return err
 	}
 
-	return http.StatusOK
+	after := *before
+	after.Deleted = true
+	return recordRevision(ctx, tx, int64(id), RevisionDelete, actor, before, &after)
 }
 
-func (r *Repository) UpdateTodo(id int, todo TodoUpdater) int {
-	var namePart string
+func updateTodo(ctx context.Context, tx querier, id int, todo TodoUpdater, actor string) error {
+	query := newUpdateQuery("todo.todo_list")
+	setUpdatable(query, "title", todo.Name)
+	setUpdatable(query, "description", todo.Description)
+
+	before, err := selectSnapshot(ctx, tx, int64(id))
+	if err != nil {
+		return err
+	}
+	if before == nil || before.Deleted {
+		if todo.Version != 0 {
+			return ErrVersionMismatch
+		}
+
+		return newError(ErrNotFound, "todo %v does not exist", id)
+	}
+
+	if query.empty() {
+		return nil
+	}
+
+	query.setExpr("version = version + 1").where("id = ?", id).where("deleted_at IS NULL")
+	if err := execVersioned(ctx, tx, query, todo.Version); err != nil {
+		return err
+	}
+
+	after := *before
 	if todo.Name.Updatable {
-		namePart = fmt.Sprintf("title = '%v'", todo.Name.Value)
-	} else {
-		namePart = ""
+		after.Name = todo.Name.Value
 	}
+	if todo.Description.Updatable {
+		after.Description = todo.Description.Value
+	}
+	return recordRevision(ctx, tx, int64(id), RevisionUpdate, actor, before, &after)
+}
 
-	doUpdate := false
-	for _, update := range []bool{todo.Name.Updatable} {
-		if update {
-			doUpdate = true
-		}
+// execVersioned runs the UPDATE with the optimistic lock condition.
+// Zero version leaves the statement unconditional.
+// When no row is updated it reports ErrVersionMismatch if the version was
+// checked, and ErrNotFound otherwise.
+func execVersioned(ctx context.Context, tx querier, query *updateQuery, version int) error {
+	if version != 0 {
+		query.where("version = ?", version)
 	}
 
-	if doUpdate {
-		sql := fmt.Sprintf("UPDATE todo.todo_list SET %v WHERE id = %v", namePart, id)
-		return r.beginTx(func() error {
-			if _, err := r.db.Exec(sql); err != nil {
-				return err
-			}
+	updated, err := query.exec(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		if version != 0 {
+			return ErrVersionMismatch
+		}
 
-			return nil
-		})
-	} else {
-		return http.StatusOK
+		return newError(ErrNotFound, "no todo is updated")
 	}
 
+	return nil
 }
 
-func (r *Repository) GetUserInfo(username string) (*UserInfo, int) {
-	sql := fmt.Sprintf("SELECT username, passwd FROM auth.users WHERE username=?;")
-	rows, err := r.db.Query(sql, username)
+func (r *Repository) GetTrash(ctx context.Context) ([]TrashedTodo, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	rows, err := r.conn().QueryContext(
+		ctx,
+		"SELECT id, title, description, deleted_at FROM todo.todo_list WHERE deleted_at IS NOT NULL ORDER BY deleted_at, id",
+	)
 	if err != nil {
-		return nil, http.StatusUnauthorized
+		return nil, classify(err)
 	}
+	defer rows.Close()
 
-	if !rows.Next() {
-		return nil, http.StatusUnauthorized
+	resp := []TrashedTodo{}
+	for rows.Next() {
+		var todo TrashedTodo
+
+		if err := rows.Scan(&todo.Id, &todo.Name, &todo.Description, &todo.DeletedAt); err != nil {
+			return nil, classify(err)
+		}
+
+		resp = append(resp, todo)
 	}
 
+	return resp, classify(rows.Err())
+}
+
+func (r *Repository) RestoreTodo(ctx context.Context, id uint, actor string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	return r.beginTx(ctx, func(tx querier) error {
+		before, err := selectSnapshot(ctx, tx, int64(id))
+		if err != nil {
+			return err
+		}
+		if before == nil || !before.Deleted {
+			return newError(ErrNotFound, "todo %v is not in the trash", id)
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE todo.todo_list SET deleted_at = NULL, version = version + 1 WHERE id = ?", id); err != nil {
+			return err
+		}
+
+		after := *before
+		after.Deleted = false
+		return recordRevision(ctx, tx, int64(id), RevisionRestore, actor, before, &after)
+	})
+}
+
+func (r *Repository) PurgeTrash(ctx context.Context, before time.Time) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Purge)
+	defer cancel()
+
+	return r.beginTx(ctx, func(tx querier) error {
+		_, err := tx.ExecContext(
+			ctx,
+			"DELETE FROM todo.todo_list WHERE deleted_at IS NOT NULL AND deleted_at < ?",
+			before,
+		)
+
+		return err
+	})
+}
+
+func (r *Repository) GetUserInfo(ctx context.Context, username string) (*UserInfo, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
 	var user, password string
-	if err := rows.Scan(&user, &password); err != nil {
-		return nil, http.StatusUnauthorized
+	err := r.conn().QueryRowContext(
+		ctx,
+		"SELECT username, passwd FROM auth.users WHERE username=?",
+		username,
+	).Scan(&user, &password)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, newError(ErrNotFound, "user %v", username)
+	case err != nil:
+		return nil, classify(err)
 	}
 
 	hashedPassword, err := hex.DecodeString(password)
-	if err != nil {
-		return nil, http.StatusUnauthorized
+	if err != nil || len(hashedPassword) != 32 {
+		return nil, newError(ErrUnavailable, "malformed password hash of user %v", username)
 	}
 
 	info := UserInfo{
@@ -222,42 +412,49 @@ func (r *Repository) GetUserInfo(username string) (*UserInfo, int) {
 		HashedPassword: (*[32]byte)(hashedPassword),
 	}
 
-	return &info, http.StatusOK
+	return &info, nil
 }
 
-func (r *Repository) GetSessionHash(username string) (*[32]byte, int) {
-	rows, err := r.db.Query(
+// GetSessionHash returns the session hash of the user,
+// or nil if the user has never logged in.
+func (r *Repository) GetSessionHash(ctx context.Context, username string) (*[32]byte, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var sessionHash sql.NullString
+	err := r.conn().QueryRowContext(
+		ctx,
 		"SELECT session_hash FROM auth.users WHERE username=?",
 		username,
-	)
+	).Scan(&sessionHash)
 
-	if err != nil {
-		log.Print(err)
-		return nil, http.StatusUnauthorized
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, newError(ErrNotFound, "user %v", username)
+	case err != nil:
+		return nil, classify(err)
 	}
 
-	if !rows.Next() {
-		log.Print(err)
-		return nil, http.StatusUnauthorized
+	if sessionHash.String == "" {
+		return nil, nil
 	}
 
-	var sessionHash sql.NullString
-	if err := rows.Scan(&sessionHash); err != nil {
-		log.Print(err)
-		return nil, http.StatusUnauthorized
-	}
-	if sessionHash.String == "" {
-		return nil, http.StatusOK
-	} else {
-		hash, _ := hex.DecodeString(sessionHash.String)
-		return (*[32]byte)(hash), http.StatusOK
+	hash, err := hex.DecodeString(sessionHash.String)
+	if err != nil || len(hash) != 32 {
+		return nil, newError(ErrUnavailable, "malformed session hash of user %v", username)
 	}
+
+	return (*[32]byte)(hash), nil
 }
 
-func (r *Repository) SetSessionHash(username string, hash [32]byte) int {
-	return r.beginTx(func() error {
+func (r *Repository) SetSessionHash(ctx context.Context, username string, hash [32]byte) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	return r.beginTx(ctx, func(tx querier) error {
 		sessionHash := hex.EncodeToString(hash[:])
-		_, err := r.db.Exec(
+		_, err := tx.ExecContext(
+			ctx,
 			"UPDATE auth.users SET session_hash = ? WHERE username = ?",
 			sessionHash,
 			username,
@@ -1,10 +1,12 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"os"
 	"testing"
 	"time"
 
@@ -31,28 +33,122 @@ var initDBData = []TodoResponse{
 	},
 }
 
+// testStorage selects the database the tests run against. They use an in-memory
+// SQLite database unless TEST_STORAGE is set to mysql, which needs the MySQL host
+// db.test, or to postgres, which needs a PostgreSQL database at TEST_POSTGRES_DSN.
+var testStorage = os.Getenv("TEST_STORAGE")
+
 func init() {
-	dsn := fmt.Sprintf("%v:%v@(%v)/%v", "app", "app", "db.test", "todo")
-	txdb.Register("txdb", "mysql", dsn)
+	switch testStorage {
+	case "mysql":
+		dsn := fmt.Sprintf("%v:%v@(%v)/%v?parseTime=true", "app", "app", "db.test", "todo")
+		if err := prepareMySQL(dsn); err != nil {
+			panic(err)
+		}
+		txdb.Register("txdb", "mysql", dsn)
+	case "postgres":
+		txdb.Register("txdb", "postgres", os.Getenv("TEST_POSTGRES_DSN"))
+	}
 }
 
 func createRepository() *Repository {
-	db, err := sql.Open("txdb", uuid.New().String())
+	switch testStorage {
+	case "mysql":
+		db, err := sql.Open("txdb", uuid.New().String())
+		if err != nil {
+			panic(err)
+		}
+
+		return NewRepository(db)
+	case "postgres":
+		// Every test runs in its own transaction, which is rolled back
+		// together with the schema and the rows when the db is closed.
+		db, err := sql.Open("txdb", uuid.New().String())
+		if err != nil {
+			panic(err)
+		}
+		if err := migrate(db, "postgres"); err != nil {
+			panic(err)
+		}
+		if _, err := db.Exec("TRUNCATE todo.todo_list, todo.todo_revision, todo.idempotency_key, auth.users RESTART IDENTITY"); err != nil {
+			panic(err)
+		}
+
+		repo := NewPostgresRepository(db)
+		seed(repo)
+
+		return repo
+	}
+
+	db, err := openMigrated(":memory:")
 	if err != nil {
 		panic(err)
 	}
 
-	repo := NewRepository(db)
+	repo := NewSQLiteRepository(db)
+	seed(repo)
 
 	return repo
 }
 
+// prepareMySQL migrates the database at dsn and seeds it unless it has todos.
+// MySQL commits schema changes implicitly, so they cannot be made in the
+// transaction of each test like PostgreSQL.
+func prepareMySQL(dsn string) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := migrate(db, "mysql"); err != nil {
+		return err
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM todo.todo_list").Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		seed(NewRepository(db))
+	}
+
+	return nil
+}
+
+// seed inserts the todos of initDBData and the users whose passwords are their names.
+func seed(repo *Repository) {
+	ctx := context.Background()
+	for _, todo := range initDBData {
+		if _, err := repo.conn().ExecContext(ctx, "INSERT INTO todo.todo_list (title) VALUES (?)", todo.Name); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, user := range []string{"Taro", "Hanako", "Ryota"} {
+		password := fmt.Sprintf("%x", sha256.Sum256([]byte(user)))
+		if _, err := repo.conn().ExecContext(ctx, "INSERT INTO auth.users (username, passwd) VALUES (?, ?)", user, password); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func getTrash(rep *Repository) []TrashedTodo {
+	trash, err := rep.GetTrash(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	return trash
+}
+
 func TestGetAllTodos(t *testing.T) {
 	rep := createRepository()
 	defer rep.db.Close()
 
 	expectTodos := initDBData
-	actualTodos := rep.GetAllTodos()
+	actualTodos, err := rep.GetAllTodos(context.Background())
+	assert.Nil(t, err)
 
 	assert.Equal(t, len(expectTodos), len(actualTodos))
 	for i := range expectTodos {
@@ -79,11 +175,11 @@ func TestPostTodo(t *testing.T) {
 	expectTodos := append(initDBData, postTodos...)
 
 	for _, todo := range postTodos {
-		status := rep.PostTodo(todo)
-		assert.Equal(t, status, http.StatusOK)
+		err := rep.PostTodo(context.Background(), todo, "tester")
+		assert.Nil(t, err)
 	}
 
-	actualTodos := rep.GetAllTodos()
+	actualTodos, _ := rep.GetAllTodos(context.Background())
 	assert.NotNil(t, actualTodos)
 
 	assert.Equal(t, len(expectTodos), len(actualTodos))
@@ -97,12 +193,12 @@ func TestDeleteTodo(t *testing.T) {
 		rep := createRepository()
 		defer rep.db.Close()
 
-		status := rep.DeleteTodo(1)
-		assert.Equal(t, status, http.StatusOK)
+		err := rep.DeleteTodo(context.Background(), 1, 0, "tester")
+		assert.Nil(t, err)
 
 		expected := initDBData[1:]
 
-		todos := rep.GetAllTodos()
+		todos, _ := rep.GetAllTodos(context.Background())
 		assert.NotNil(t, todos)
 		assert.Equal(t, 2, len(todos))
 		for i, todo := range todos {
@@ -114,10 +210,10 @@ func TestDeleteTodo(t *testing.T) {
 		repo := createRepository()
 		defer repo.db.Close()
 
-		status := repo.DeleteTodo(4)
-		assert.Equal(t, http.StatusOK, status)
+		err := repo.DeleteTodo(context.Background(), 4, 0, "tester")
+		assert.Nil(t, err)
 
-		todos := repo.GetAllTodos()
+		todos, _ := repo.GetAllTodos(context.Background())
 		assert.Equal(t, 3, len(todos))
 		for i, todo := range todos {
 			assert.Equal(t, initDBData[i].Name, todo.Name)
@@ -125,23 +221,279 @@ func TestDeleteTodo(t *testing.T) {
 	})
 }
 
+func TestTrash(t *testing.T) {
+	t.Run("deleted todo is moved to trash", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		err := rep.DeleteTodo(context.Background(), 2, 0, "tester")
+		assert.Nil(t, err)
+
+		trash, _ := rep.GetTrash(context.Background())
+		assert.Equal(t, 1, len(trash))
+		assert.Equal(t, initDBData[1].Id, trash[0].Id)
+		assert.Equal(t, initDBData[1].Name, trash[0].Name)
+		assert.False(t, trash[0].DeletedAt.IsZero())
+	})
+
+	t.Run("restore todo from trash", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		rep.DeleteTodo(context.Background(), 2, 0, "tester")
+		err := rep.RestoreTodo(context.Background(), 2, "tester")
+		assert.Nil(t, err)
+
+		assert.Equal(t, 0, len(getTrash(rep)))
+		todos, _ := rep.GetAllTodos(context.Background())
+		assert.Equal(t, len(initDBData), len(todos))
+		for i, todo := range todos {
+			assert.Equal(t, initDBData[i].Name, todo.Name)
+		}
+	})
+
+	t.Run("restore todo not in trash", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		assert.ErrorIs(t, rep.RestoreTodo(context.Background(), 1, "tester"), ErrNotFound)
+		assert.ErrorIs(t, rep.RestoreTodo(context.Background(), 4, "tester"), ErrNotFound)
+	})
+
+	t.Run("purge removes todo deleted before given time", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		rep.DeleteTodo(context.Background(), 1, 0, "tester")
+		rep.DeleteTodo(context.Background(), 2, 0, "tester")
+		rep.conn().ExecContext(context.Background(), "UPDATE todo.todo_list SET deleted_at = ? WHERE id = 1", time.Now().Add(-48*time.Hour))
+
+		err := rep.PurgeTrash(context.Background(), time.Now().Add(-24*time.Hour))
+		assert.Nil(t, err)
+
+		trash, _ := rep.GetTrash(context.Background())
+		assert.Equal(t, 1, len(trash))
+		assert.Equal(t, 2, trash[0].Id)
+		assert.ErrorIs(t, rep.RestoreTodo(context.Background(), 1, "tester"), ErrNotFound)
+	})
+}
+
+func TestSearchTodos(t *testing.T) {
+	// InnoDB full-text index reflects only committed rows,
+	// so the search is tested against the initial data.
+	t.Run("search todos by word", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		page, err := rep.SearchTodos(context.Background(), "ramen", 0, 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, page.Total)
+		assert.Equal(t, 1, len(page.Results))
+		assert.Equal(t, initDBData[2].Name, page.Results[0].Todo.Name)
+		assert.Greater(t, page.Results[0].Score, 0.0)
+	})
+
+	t.Run("search results are paginated", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		page, err := rep.SearchTodos(context.Background(), "water ramen minutes", 2, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, page.Total)
+		assert.Equal(t, 1, len(page.Results))
+	})
+
+	t.Run("deleted todo is not found", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		rep.DeleteTodo(context.Background(), 3, 0, "tester")
+
+		page, err := rep.SearchTodos(context.Background(), "ramen", 0, 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, page.Total)
+		assert.Equal(t, 0, len(page.Results))
+	})
+}
+
+func TestTodoVersion(t *testing.T) {
+	update := func(rep *Repository, id int, name string, version int) error {
+		return rep.UpdateTodo(context.Background(), id, TodoUpdater{
+			Id: id,
+			Name: Updatable[string]{
+				Updatable: true,
+				Value:     name,
+			},
+			Version: version,
+		}, "tester")
+	}
+
+	t.Run("get todo with version", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		todo, err := rep.GetTodo(context.Background(), 1)
+		assert.Nil(t, err)
+		assert.Equal(t, initDBData[0].Name, todo.Name)
+		assert.Equal(t, 1, todo.Version)
+
+		todo, err = rep.GetTodo(context.Background(), 4)
+		assert.Nil(t, todo)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("every write increments version", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		assert.Nil(t, update(rep, 1, "title updated", 1))
+		todo, _ := rep.GetTodo(context.Background(), 1)
+		assert.Equal(t, 2, todo.Version)
+
+		assert.Nil(t, rep.DeleteTodo(context.Background(), 1, 2, "tester"))
+		assert.Nil(t, rep.RestoreTodo(context.Background(), 1, "tester"))
+		todo, _ = rep.GetTodo(context.Background(), 1)
+		assert.Equal(t, 4, todo.Version)
+	})
+
+	t.Run("stale version cause precondition failed", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		update(rep, 1, "title updated", 0)
+
+		assert.ErrorIs(t, update(rep, 1, "conflict", 1), ErrVersionMismatch)
+		assert.ErrorIs(t, rep.DeleteTodo(context.Background(), 1, 1, "tester"), ErrVersionMismatch)
+		assert.ErrorIs(t, rep.DeleteTodo(context.Background(), 4, 1, "tester"), ErrVersionMismatch)
+
+		todos, _ := rep.GetAllTodos(context.Background())
+		assert.Equal(t, len(initDBData), len(todos))
+		assert.Equal(t, "title updated", todos[0].Name)
+	})
+}
+
+func TestGetTodoListMetadata(t *testing.T) {
+	rep := createRepository()
+	defer rep.db.Close()
+
+	before, err := rep.GetTodoListMetadata(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, len(initDBData), before.Count)
+	assert.Equal(t, int64(len(initDBData)), before.VersionSum)
+	assert.False(t, before.LastModified.IsZero())
+
+	todos, _ := rep.GetAllTodos(context.Background())
+	assert.Equal(t, before.LastModified, todos[len(todos)-1].UpdatedAt)
+
+	rep.DeleteTodo(context.Background(), 1, 0, "tester")
+
+	after, err := rep.GetTodoListMetadata(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, before.Count, after.Count)
+	assert.Equal(t, before.VersionSum+1, after.VersionSum)
+	assert.False(t, after.LastModified.Before(before.LastModified))
+}
+
+func TestTodoHistory(t *testing.T) {
+	rename := func(rep *Repository, id int, name string) error {
+		return rep.UpdateTodo(context.Background(), id, TodoUpdater{
+			Id: id,
+			Name: Updatable[string]{
+				Updatable: true,
+				Value:     name,
+			},
+		}, "tester")
+	}
+
+	t.Run("create, update and delete are recorded", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		rep.PostTodo(context.Background(), TodoResponse{Name: "power on"}, "tester")
+		todos, _ := rep.GetAllTodos(context.Background())
+		id := todos[len(todos)-1].Id
+
+		rename(rep, id, "power off")
+		rep.DeleteTodo(context.Background(), uint(id), 0, "another")
+
+		revisions, err := rep.GetTodoHistory(context.Background(), uint(id))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(revisions))
+
+		assert.Equal(t, RevisionCreate, revisions[0].Action)
+		assert.Equal(t, nil, revisions[0].Diff["name"].Old)
+		assert.Equal(t, "power on", revisions[0].Diff["name"].New)
+
+		assert.Equal(t, RevisionUpdate, revisions[1].Action)
+		assert.Equal(t, "tester", revisions[1].Actor)
+		assert.Equal(t, "power on", revisions[1].Diff["name"].Old)
+		assert.Equal(t, "power off", revisions[1].Diff["name"].New)
+
+		assert.Equal(t, RevisionDelete, revisions[2].Action)
+		assert.Equal(t, "another", revisions[2].Actor)
+		assert.Equal(t, false, revisions[2].Diff["deleted"].Old)
+		assert.Equal(t, true, revisions[2].Diff["deleted"].New)
+
+		for i, rev := range revisions {
+			assert.Equal(t, i+1, rev.Rev)
+			assert.False(t, rev.CreatedAt.IsZero())
+		}
+	})
+
+	t.Run("history of unknown todo", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		revisions, err := rep.GetTodoHistory(context.Background(), 4)
+		assert.Nil(t, revisions)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("revert to previous revision", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		rename(rep, 1, "boil water")
+		rename(rep, 1, "boil milk")
+		rep.DeleteTodo(context.Background(), 1, 0, "tester")
+
+		err := rep.RevertTodo(context.Background(), 1, 1, "tester")
+		assert.Nil(t, err)
+
+		todos, _ := rep.GetAllTodos(context.Background())
+		assert.Equal(t, "boil water", todos[0].Name)
+
+		revisions, _ := rep.GetTodoHistory(context.Background(), 1)
+		assert.Equal(t, 4, len(revisions))
+		assert.Equal(t, RevisionRevert, revisions[3].Action)
+		assert.Equal(t, TodoSnapshot{Name: "boil water", Deleted: false}, revisions[3].Snapshot)
+	})
+
+	t.Run("revert to unknown revision", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		assert.ErrorIs(t, rep.RevertTodo(context.Background(), 1, 1, "tester"), ErrNotFound)
+	})
+}
+
 func TestUpdateTodo(t *testing.T) {
 	t.Run("update existance todo", func(t *testing.T) {
 		rep := createRepository()
 		defer rep.db.Close()
 
 		updatedTitle := "title updated"
-		status := rep.UpdateTodo(1, TodoUpdater{
+		err := rep.UpdateTodo(context.Background(), 1, TodoUpdater{
 			Id: 1,
 			Name: Updatable[string]{
 				Updatable: true,
 				Value:     updatedTitle,
 			},
-		})
+		}, "tester")
 
-		assert.Equal(t, http.StatusOK, status)
+		assert.Nil(t, err)
 
-		todos := rep.GetAllTodos()
+		todos, _ := rep.GetAllTodos(context.Background())
 		assert.NotNil(t, todos)
 		assert.Equal(t, todos[0].Name, updatedTitle)
 
@@ -156,17 +508,17 @@ func TestUpdateTodo(t *testing.T) {
 		defer rep.db.Close()
 
 		updateTitle := "title updated"
-		status := rep.UpdateTodo(4, TodoUpdater{
+		err := rep.UpdateTodo(context.Background(), 4, TodoUpdater{
 			Id: 4,
 			Name: Updatable[string]{
 				Updatable: true,
 				Value:     updateTitle,
 			},
-		})
+		}, "tester")
 
-		assert.Equal(t, http.StatusOK, status)
+		assert.ErrorIs(t, err, ErrNotFound)
 
-		todos := rep.GetAllTodos()
+		todos, _ := rep.GetAllTodos(context.Background())
 		for i, todo := range todos {
 			assert.Equal(t, initDBData[i].Name, todo.Name)
 		}
@@ -176,17 +528,17 @@ func TestUpdateTodo(t *testing.T) {
 		rep := createRepository()
 		defer rep.db.Close()
 
-		status := rep.UpdateTodo(1, TodoUpdater{
+		err := rep.UpdateTodo(context.Background(), 1, TodoUpdater{
 			Id: 1,
 			Name: Updatable[string]{
 				Updatable: false,
 				Value:     "",
 			},
-		})
+		}, "tester")
 
-		assert.Equal(t, http.StatusOK, status)
+		assert.Nil(t, err)
 
-		todos := rep.GetAllTodos()
+		todos, _ := rep.GetAllTodos(context.Background())
 		for i, todo := range todos {
 			assert.Equal(t, initDBData[i].Name, todo.Name)
 		}
@@ -196,19 +548,86 @@ func TestUpdateTodo(t *testing.T) {
 		rep := createRepository()
 		defer rep.db.Close()
 
-		status := rep.UpdateTodo(1, TodoUpdater{
+		err := rep.UpdateTodo(context.Background(), 1, TodoUpdater{
 			Id: 1,
 			Name: Updatable[string]{
 				Updatable: true,
 				Value:     "",
 			},
-		})
+		}, "tester")
 
-		assert.Equal(t, http.StatusOK, status)
+		assert.Nil(t, err)
 
-		todos := rep.GetAllTodos()
+		todos, _ := rep.GetAllTodos(context.Background())
 		assert.Equal(t, "", todos[0].Name)
 	})
+
+	t.Run("update with quotes", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		name := "it's done'; DELETE FROM todo.todo_list; --"
+		err := rep.UpdateTodo(context.Background(), 1, TodoUpdater{
+			Id:          1,
+			Name:        Updatable[string]{Updatable: true, Value: name},
+			Description: Updatable[string]{Updatable: true, Value: `"quoted" \ description`},
+		}, "tester")
+
+		assert.Nil(t, err)
+
+		todos, _ := rep.GetAllTodos(context.Background())
+		assert.Equal(t, len(initDBData), len(todos))
+		assert.Equal(t, name, todos[0].Name)
+		assert.Equal(t, `"quoted" \ description`, todos[0].Description)
+	})
+}
+
+func TestIdempotentResponse(t *testing.T) {
+	request := IdempotentResponse{Actor: "Taro", Key: "key-1", RequestHash: "hash"}
+
+	t.Run("reserve, save and get response", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		err := rep.ReserveIdempotentResponse(context.Background(), request, time.Now().Add(-time.Hour))
+		assert.Nil(t, err)
+
+		resp := request
+		resp.Status = http.StatusOK
+		resp.Header = map[string]string{"ETag": `"1"`}
+		resp.Body = []byte("{}")
+		assert.Nil(t, rep.SaveIdempotentResponse(context.Background(), resp))
+
+		stored, err := rep.GetIdempotentResponse(context.Background(), "Taro", "key-1", time.Now().Add(-time.Hour))
+		assert.Nil(t, err)
+		assert.Equal(t, "hash", stored.RequestHash)
+		assert.Equal(t, http.StatusOK, stored.Status)
+		assert.Equal(t, `"1"`, stored.Header["ETag"])
+		assert.Equal(t, []byte("{}"), stored.Body)
+
+		stored, err = rep.GetIdempotentResponse(context.Background(), "Hanako", "key-1", time.Now().Add(-time.Hour))
+		assert.Nil(t, stored)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("expired response is ignored, replaced and purged", func(t *testing.T) {
+		rep := createRepository()
+		defer rep.db.Close()
+
+		rep.ReserveIdempotentResponse(context.Background(), request, time.Now().Add(-time.Hour))
+		rep.conn().ExecContext(context.Background(), "UPDATE todo.idempotency_key SET created_at = ?", time.Now().Add(-48*time.Hour))
+
+		_, err := rep.GetIdempotentResponse(context.Background(), "Taro", "key-1", time.Now().Add(-24*time.Hour))
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		err = rep.ReserveIdempotentResponse(context.Background(), request, time.Now().Add(-24*time.Hour))
+		assert.Nil(t, err)
+		rep.conn().ExecContext(context.Background(), "UPDATE todo.idempotency_key SET created_at = ?", time.Now().Add(-48*time.Hour))
+
+		assert.Nil(t, rep.PurgeIdempotentResponses(context.Background(), time.Now().Add(-24*time.Hour)))
+		_, err = rep.GetIdempotentResponse(context.Background(), "Taro", "key-1", time.Now().Add(-72*time.Hour))
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
 }
 
 func TestGetUserInfo(t *testing.T) {
@@ -216,9 +635,9 @@ func TestGetUserInfo(t *testing.T) {
 		rep := createRepository()
 		defer rep.db.Close()
 
-		userInfo, status := rep.GetUserInfo("Taro")
+		userInfo, err := rep.GetUserInfo(context.Background(), "Taro")
 
-		assert.Equal(t, http.StatusOK, status)
+		assert.Nil(t, err)
 		assert.Equal(t, "Taro", userInfo.Username)
 		assert.Equal(t, sha256.Sum256([]byte("Taro")), *userInfo.HashedPassword)
 	})
@@ -227,10 +646,10 @@ func TestGetUserInfo(t *testing.T) {
 		rep := createRepository()
 		defer rep.db.Close()
 
-		userInfo, status := rep.GetUserInfo("Unknown")
+		userInfo, err := rep.GetUserInfo(context.Background(), "Unknown")
 
 		assert.Nil(t, userInfo)
-		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.ErrorIs(t, err, ErrNotFound)
 	})
 }
 
@@ -242,33 +661,33 @@ func TestGetSessionHash(t *testing.T) {
 		hashSeed := fmt.Sprintf("%08x/%v", uint64(time.Now().Unix()), "Taro")
 		hashExpect := sha256.Sum256([]byte(hashSeed))
 		hash := fmt.Sprintf("%x", hashExpect)
-		rep.db.Exec("UPDATE auth.users SET session_hash=? WHERE username='Taro'", hash)
+		rep.conn().ExecContext(context.Background(), "UPDATE auth.users SET session_hash=? WHERE username='Taro'", hash)
 
-		hashActual, status := rep.GetSessionHash("Taro")
+		hashActual, err := rep.GetSessionHash(context.Background(), "Taro")
 
 		assert.NotNil(t, hashActual)
 		assert.Equal(t, hashExpect, *hashActual)
-		assert.Equal(t, http.StatusOK, status)
+		assert.Nil(t, err)
 	})
 
 	t.Run("get session but NULL", func(t *testing.T) {
 		rep := createRepository()
 		defer rep.db.Close()
 
-		sessionHash, status := rep.GetSessionHash("Taro")
+		sessionHash, err := rep.GetSessionHash(context.Background(), "Taro")
 
 		assert.Nil(t, sessionHash)
-		assert.Equal(t, http.StatusOK, status)
+		assert.Nil(t, err)
 	})
 
 	t.Run("get session hash by invalid username", func(t *testing.T) {
 		rep := createRepository()
 		defer rep.db.Close()
 
-		sessionHash, status := rep.GetSessionHash("Unknown")
+		sessionHash, err := rep.GetSessionHash(context.Background(), "Unknown")
 
 		assert.Nil(t, sessionHash)
-		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.ErrorIs(t, err, ErrNotFound)
 	})
 }
 
@@ -278,11 +697,11 @@ func TestSetSessionHash(t *testing.T) {
 		defer rep.db.Close()
 
 		hash := sha256.Sum256([]byte{1, 2, 3})
-		status := rep.SetSessionHash("Taro", hash)
+		err := rep.SetSessionHash(context.Background(), "Taro", hash)
 
-		assert.Equal(t, http.StatusOK, status)
+		assert.Nil(t, err)
 
-		rows, err := rep.db.Query("SELECT session_hash FROM auth.users WHERE username='Taro'")
+		rows, err := rep.conn().QueryContext(context.Background(), "SELECT session_hash FROM auth.users WHERE username='Taro'")
 		if err != nil {
 			panic(err)
 		}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// dialect adapts the queries of Repository, which are written for MySQL,
+// to the database actually storing the todos.
+type dialect struct {
+	name string
+	// rewrite turns a MySQL query into the one of the dialect.
+	rewrite func(query string) string
+	// arg converts an argument before it is passed to the driver.
+	arg func(v any) any
+	// fullText is true when MATCH ... AGAINST can be used for search.
+	// Otherwise todos are ranked by RankTodos.
+	fullText bool
+	// returning is true when the id of an inserted row is returned
+	// by RETURNING id instead of LastInsertId.
+	returning bool
+}
+
+var mysqlDialect = &dialect{
+	name:     "mysql",
+	rewrite:  func(query string) string { return query },
+	arg:      func(v any) any { return v },
+	fullText: true,
+}
+
+// dialectConn runs queries rewritten for the dialect.
+type dialectConn struct {
+	conn    sqlConn
+	dialect *dialect
+}
+
+func (d *dialect) wrap(conn sqlConn) querier {
+	return dialectConn{conn: conn, dialect: d}
+}
+
+func (d *dialect) args(args []any) []any {
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		converted[i] = d.arg(arg)
+	}
+
+	return converted
+}
+
+func (c dialectConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.conn.ExecContext(ctx, c.dialect.rewrite(query), c.dialect.args(args)...)
+}
+
+func (c dialectConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.conn.QueryContext(ctx, c.dialect.rewrite(query), c.dialect.args(args)...)
+}
+
+func (c dialectConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.conn.QueryRowContext(ctx, c.dialect.rewrite(query), c.dialect.args(args)...)
+}
+
+func (c dialectConn) InsertContext(ctx context.Context, query string, args ...any) (int64, error) {
+	if c.dialect.returning {
+		var id int64
+		err := c.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+
+		return id, err
+	}
+
+	result, err := c.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// textTime scans a timestamp which some drivers return as text,
+// such as an aggregate of DATETIME columns in SQLite.
+type textTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+var textTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+}
+
+func (t *textTime) Scan(value any) error {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		t.Time, t.Valid = time.Time{}, false
+		return nil
+	case time.Time:
+		t.Time, t.Valid = v, true
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a timestamp", value)
+	}
+
+	for _, layout := range textTimeLayouts {
+		if parsed, err := time.Parse(layout, text); err == nil {
+			t.Time, t.Valid = parsed, true
+			return nil
+		}
+	}
+
+	return fmt.Errorf("malformed timestamp: %v", text)
+}
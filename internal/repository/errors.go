@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// Kinds of failure reported by TodoListManipulation.
+// Errors returned by the repository wrap one of them, so callers
+// should check the kind with errors.Is.
+var (
+	// ErrNotFound means the todo, revision or user does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the request contradicts the current state of the storage.
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable means the storage failed or could not be reached.
+	ErrUnavailable = errors.New("storage unavailable")
+	// ErrInvalid means the storage rejected the given values.
+	ErrInvalid = errors.New("invalid argument")
+	// ErrTimeout means the operation did not finish within its timeout.
+	ErrTimeout = errors.New("storage timeout")
+)
+
+// ErrVersionMismatch is a conflict where the todo does not have
+// the version the caller expected.
+var ErrVersionMismatch = &Error{Kind: ErrConflict, Err: errors.New("version mismatch")}
+
+// Error is a failure of the repository. Kind is one of the kinds above
+// and Err is the underlying cause.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(kind error, format string, args ...any) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// MySQL error numbers translated to ErrConflict or ErrInvalid.
+const (
+	mysqlDuplicateEntry = 1062
+	mysqlBadNull        = 1048
+	mysqlDataTooLong    = 1406
+	mysqlNoReferenced   = 1452
+)
+
+// PostgreSQL error codes translated to ErrConflict or ErrInvalid.
+const (
+	postgresUniqueViolation     = "23505"
+	postgresNotNullViolation    = "23502"
+	postgresForeignKeyViolation = "23503"
+	postgresCheckViolation      = "23514"
+	postgresStringTooLong       = "22001"
+)
+
+// classify gives a kind to an error from database/sql.
+// Errors which already have a kind are returned as they are.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var repoErr *Error
+	if errors.As(err, &repoErr) {
+		return err
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Kind: ErrTimeout, Err: err}
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, Err: err}
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlDuplicateEntry:
+			return &Error{Kind: ErrConflict, Err: err}
+		case mysqlBadNull, mysqlDataTooLong, mysqlNoReferenced:
+			return &Error{Kind: ErrInvalid, Err: err}
+		}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case postgresUniqueViolation:
+			return &Error{Kind: ErrConflict, Err: err}
+		case postgresNotNullViolation, postgresForeignKeyViolation, postgresCheckViolation, postgresStringTooLong:
+			return &Error{Kind: ErrInvalid, Err: err}
+		}
+	}
+
+	if kind := sqliteErrorKind(err); kind != nil {
+		return &Error{Kind: kind, Err: err}
+	}
+
+	return &Error{Kind: ErrUnavailable, Err: err}
+}
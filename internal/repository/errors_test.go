@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	assert.Nil(t, classify(nil))
+
+	cases := map[error]error{
+		sql.ErrNoRows: ErrNotFound,
+		&mysql.MySQLError{Number: mysqlDuplicateEntry}: ErrConflict,
+		&mysql.MySQLError{Number: mysqlDataTooLong}:    ErrInvalid,
+		&mysql.MySQLError{Number: 1213}:                ErrUnavailable,
+		&pq.Error{Code: postgresUniqueViolation}:       ErrConflict,
+		&pq.Error{Code: postgresStringTooLong}:         ErrInvalid,
+		&pq.Error{Code: postgresDeadlock}:              ErrUnavailable,
+		mysql.ErrInvalidConn:                           ErrUnavailable,
+		fmt.Errorf("todo 1: %w", ErrVersionMismatch):   ErrConflict,
+		context.DeadlineExceeded:                       ErrTimeout,
+	}
+
+	for err, kind := range cases {
+		classified := classify(err)
+		assert.ErrorIs(t, classified, kind, "%v", err)
+		assert.ErrorIs(t, classified, err, "cause must be kept: %v", err)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	err := newError(ErrNotFound, "todo %v", 1)
+
+	assert.Equal(t, "not found: todo 1", err.Error())
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, errors.Is(err, ErrConflict))
+
+	assert.ErrorIs(t, ErrVersionMismatch, ErrConflict)
+	assert.False(t, errors.Is(ErrConflict, ErrVersionMismatch))
+}
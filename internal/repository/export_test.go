@@ -0,0 +1,12 @@
+package repository
+
+import "testing"
+
+// NewTestRepository returns the repository of createRepository
+// to the tests outside of the package, and closes it after the test.
+func NewTestRepository(t *testing.T) *Repository {
+	rep := createRepository()
+	t.Cleanup(func() { rep.db.Close() })
+
+	return rep
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// IdempotentResponse is the first response sent for an Idempotency-Key
+// of an actor. Status is zero while the request is still handled.
+type IdempotentResponse struct {
+	Actor       string
+	Key         string
+	RequestHash string
+	Status      int
+	// Header has the response headers which are replayed with Body.
+	Header    map[string]string
+	Body      []byte
+	CreatedAt time.Time
+}
+
+// GetIdempotentResponse returns the response stored for the key of the actor since the given time.
+func (r *Repository) GetIdempotentResponse(ctx context.Context, actor string, key string, since time.Time) (*IdempotentResponse, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var resp IdempotentResponse
+	var header string
+	err := r.conn().QueryRowContext(
+		ctx,
+		`SELECT actor, idem_key, request_hash, status, header, body, created_at
+		FROM todo.idempotency_key WHERE actor = ? AND idem_key = ? AND created_at >= ?`,
+		actor,
+		key,
+		since,
+	).Scan(&resp.Actor, &resp.Key, &resp.RequestHash, &resp.Status, &header, &resp.Body, &resp.CreatedAt)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, newError(ErrNotFound, "idempotency key %v", key)
+	case err != nil:
+		return nil, classify(err)
+	}
+
+	if err := json.Unmarshal([]byte(header), &resp.Header); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ReserveIdempotentResponse stores resp without its status, header and body
+// before the request is handled, replacing an expired one left for the same key.
+// It fails with ErrConflict when the key of the actor is stored since the given time,
+// even by another server.
+func (r *Repository) ReserveIdempotentResponse(ctx context.Context, resp IdempotentResponse, since time.Time) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	return r.beginTx(ctx, func(tx querier) error {
+		_, err := tx.ExecContext(
+			ctx,
+			"DELETE FROM todo.idempotency_key WHERE actor = ? AND idem_key = ? AND created_at < ?",
+			resp.Actor,
+			resp.Key,
+			since,
+		)
+		if err != nil {
+			return err
+		}
+
+		// The primary key lets only one of the concurrent requests insert the row.
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO todo.idempotency_key (actor, idem_key, request_hash, status, header, body, created_at)
+			VALUES (?, ?, ?, 0, '{}', ?, CURRENT_TIMESTAMP)`,
+			resp.Actor,
+			resp.Key,
+			resp.RequestHash,
+			[]byte{},
+		)
+
+		return err
+	})
+}
+
+// SaveIdempotentResponse stores the status, the header and the body of the response
+// for the key reserved by ReserveIdempotentResponse.
+func (r *Repository) SaveIdempotentResponse(ctx context.Context, resp IdempotentResponse) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+
+	return r.beginTx(ctx, func(tx querier) error {
+		result, err := tx.ExecContext(
+			ctx,
+			`UPDATE todo.idempotency_key SET status = ?, header = ?, body = ?
+			WHERE actor = ? AND idem_key = ? AND request_hash = ?`,
+			resp.Status,
+			string(header),
+			resp.Body,
+			resp.Actor,
+			resp.Key,
+			resp.RequestHash,
+		)
+		if err != nil {
+			return err
+		}
+
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return newError(ErrNotFound, "idempotency key %v is not reserved", resp.Key)
+		}
+
+		return nil
+	})
+}
+
+// ReleaseIdempotentResponse removes the reservation of the key whose request failed,
+// so that the request can be retried with the same key.
+func (r *Repository) ReleaseIdempotentResponse(ctx context.Context, actor string, key string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	return r.beginTx(ctx, func(tx querier) error {
+		_, err := tx.ExecContext(
+			ctx,
+			"DELETE FROM todo.idempotency_key WHERE actor = ? AND idem_key = ? AND status = 0",
+			actor,
+			key,
+		)
+
+		return err
+	})
+}
+
+func (r *Repository) PurgeIdempotentResponses(ctx context.Context, before time.Time) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Purge)
+	defer cancel()
+
+	return r.beginTx(ctx, func(tx querier) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM todo.idempotency_key WHERE created_at < ?", before)
+
+		return err
+	})
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Observer receives the name, the duration and the result of
+// every operation of an instrumented repository.
+type Observer func(operation string, duration time.Duration, err error)
+
+type instrumented struct {
+	repo    TodoListManipulation
+	observe Observer
+}
+
+// Instrument returns a repository which reports every operation of repo
+// to observe. Operations inside WithinTx are reported one by one as well.
+func Instrument(repo TodoListManipulation, observe Observer) TodoListManipulation {
+	i := new(instrumented)
+	i.repo = repo
+	i.observe = observe
+
+	return i
+}
+
+// track reports the operation which started at start and ended with err.
+func (i *instrumented) track(operation string, start time.Time, err error) {
+	i.observe(operation, time.Since(start), err)
+}
+
+func (i *instrumented) GetAllTodos(ctx context.Context) (todos []TodoResponse, err error) {
+	defer func(start time.Time) { i.track("GetAllTodos", start, err) }(time.Now())
+	return i.repo.GetAllTodos(ctx)
+}
+
+func (i *instrumented) GetTodo(ctx context.Context, id uint) (todo *TodoResponse, err error) {
+	defer func(start time.Time) { i.track("GetTodo", start, err) }(time.Now())
+	return i.repo.GetTodo(ctx, id)
+}
+
+func (i *instrumented) GetTodoListMetadata(ctx context.Context) (metadata *TodoListMetadata, err error) {
+	defer func(start time.Time) { i.track("GetTodoListMetadata", start, err) }(time.Now())
+	return i.repo.GetTodoListMetadata(ctx)
+}
+
+func (i *instrumented) SearchTodos(ctx context.Context, query string, offset int, limit int) (page *SearchPage, err error) {
+	defer func(start time.Time) { i.track("SearchTodos", start, err) }(time.Now())
+	return i.repo.SearchTodos(ctx, query, offset, limit)
+}
+
+func (i *instrumented) PostTodo(ctx context.Context, todo TodoResponse, actor string) (err error) {
+	defer func(start time.Time) { i.track("PostTodo", start, err) }(time.Now())
+	return i.repo.PostTodo(ctx, todo, actor)
+}
+
+func (i *instrumented) DeleteTodo(ctx context.Context, id uint, version int, actor string) (err error) {
+	defer func(start time.Time) { i.track("DeleteTodo", start, err) }(time.Now())
+	return i.repo.DeleteTodo(ctx, id, version, actor)
+}
+
+func (i *instrumented) UpdateTodo(ctx context.Context, id int, todo TodoUpdater, actor string) (err error) {
+	defer func(start time.Time) { i.track("UpdateTodo", start, err) }(time.Now())
+	return i.repo.UpdateTodo(ctx, id, todo, actor)
+}
+
+func (i *instrumented) GetTrash(ctx context.Context) (trash []TrashedTodo, err error) {
+	defer func(start time.Time) { i.track("GetTrash", start, err) }(time.Now())
+	return i.repo.GetTrash(ctx)
+}
+
+func (i *instrumented) RestoreTodo(ctx context.Context, id uint, actor string) (err error) {
+	defer func(start time.Time) { i.track("RestoreTodo", start, err) }(time.Now())
+	return i.repo.RestoreTodo(ctx, id, actor)
+}
+
+func (i *instrumented) GetTodoHistory(ctx context.Context, id uint) (history []TodoRevision, err error) {
+	defer func(start time.Time) { i.track("GetTodoHistory", start, err) }(time.Now())
+	return i.repo.GetTodoHistory(ctx, id)
+}
+
+func (i *instrumented) RevertTodo(ctx context.Context, id uint, rev int, actor string) (err error) {
+	defer func(start time.Time) { i.track("RevertTodo", start, err) }(time.Now())
+	return i.repo.RevertTodo(ctx, id, rev, actor)
+}
+
+func (i *instrumented) PurgeTrash(ctx context.Context, before time.Time) (err error) {
+	defer func(start time.Time) { i.track("PurgeTrash", start, err) }(time.Now())
+	return i.repo.PurgeTrash(ctx, before)
+}
+
+func (i *instrumented) GetIdempotentResponse(ctx context.Context, actor string, key string, since time.Time) (resp *IdempotentResponse, err error) {
+	defer func(start time.Time) { i.track("GetIdempotentResponse", start, err) }(time.Now())
+	return i.repo.GetIdempotentResponse(ctx, actor, key, since)
+}
+
+func (i *instrumented) ReserveIdempotentResponse(ctx context.Context, resp IdempotentResponse, since time.Time) (err error) {
+	defer func(start time.Time) { i.track("ReserveIdempotentResponse", start, err) }(time.Now())
+	return i.repo.ReserveIdempotentResponse(ctx, resp, since)
+}
+
+func (i *instrumented) SaveIdempotentResponse(ctx context.Context, resp IdempotentResponse) (err error) {
+	defer func(start time.Time) { i.track("SaveIdempotentResponse", start, err) }(time.Now())
+	return i.repo.SaveIdempotentResponse(ctx, resp)
+}
+
+func (i *instrumented) ReleaseIdempotentResponse(ctx context.Context, actor string, key string) (err error) {
+	defer func(start time.Time) { i.track("ReleaseIdempotentResponse", start, err) }(time.Now())
+	return i.repo.ReleaseIdempotentResponse(ctx, actor, key)
+}
+
+func (i *instrumented) PurgeIdempotentResponses(ctx context.Context, before time.Time) (err error) {
+	defer func(start time.Time) { i.track("PurgeIdempotentResponses", start, err) }(time.Now())
+	return i.repo.PurgeIdempotentResponses(ctx, before)
+}
+
+func (i *instrumented) GetUserInfo(ctx context.Context, username string) (info *UserInfo, err error) {
+	defer func(start time.Time) { i.track("GetUserInfo", start, err) }(time.Now())
+	return i.repo.GetUserInfo(ctx, username)
+}
+
+func (i *instrumented) GetSessionHash(ctx context.Context, username string) (hash *[32]byte, err error) {
+	defer func(start time.Time) { i.track("GetSessionHash", start, err) }(time.Now())
+	return i.repo.GetSessionHash(ctx, username)
+}
+
+func (i *instrumented) SetSessionHash(ctx context.Context, username string, hash [32]byte) (err error) {
+	defer func(start time.Time) { i.track("SetSessionHash", start, err) }(time.Now())
+	return i.repo.SetSessionHash(ctx, username, hash)
+}
+
+func (i *instrumented) WithinTx(ctx context.Context, f func(repo TodoListManipulation) error) (err error) {
+	defer func(start time.Time) { i.track("WithinTx", start, err) }(time.Now())
+	return i.repo.WithinTx(ctx, func(repo TodoListManipulation) error {
+		return f(Instrument(repo, i.observe))
+	})
+}
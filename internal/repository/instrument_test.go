@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrument(t *testing.T) {
+	ctx := context.Background()
+
+	type observation struct {
+		operation string
+		err       error
+	}
+	var mu sync.Mutex
+	var observed []observation
+	observe := func(operation string, duration time.Duration, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.GreaterOrEqual(t, duration, time.Duration(0))
+		observed = append(observed, observation{operation, err})
+	}
+
+	rep := Instrument(NewTestRepository(t), observe)
+
+	_, err := rep.GetTodo(ctx, 1)
+	assert.Nil(t, err)
+	_, err = rep.GetTodo(ctx, 100)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	failure := errors.New("failure")
+	err = rep.WithinTx(ctx, func(repo TodoListManipulation) error {
+		repo.PostTodo(ctx, TodoResponse{Name: "boil water"}, "tester")
+		return failure
+	})
+	assert.Equal(t, failure, err)
+
+	assert.Equal(t, []observation{
+		{"GetTodo", nil},
+		{"GetTodo", observed[1].err},
+		{"PostTodo", nil},
+		{"WithinTx", failure},
+	}, observed)
+	assert.ErrorIs(t, observed[1].err, ErrNotFound)
+}
@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+)
+
+// copyResponse returns resp sharing neither its header nor its body.
+func copyResponse(resp repository.IdempotentResponse) repository.IdempotentResponse {
+	header := make(map[string]string, len(resp.Header))
+	for name, value := range resp.Header {
+		header[name] = value
+	}
+	resp.Header = header
+	resp.Body = append([]byte{}, resp.Body...)
+
+	return resp
+}
+
+// GetIdempotentResponse returns the response stored for the key of the actor since the given time.
+func (r *Repository) GetIdempotentResponse(ctx context.Context, actor string, key string, since time.Time) (*repository.IdempotentResponse, error) {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	resp, ok := r.store.data.responses[responseKey{actor, key}]
+	if !ok || resp.CreatedAt.Before(since) {
+		return nil, newError(repository.ErrNotFound, "idempotency key %v", key)
+	}
+
+	resp = copyResponse(resp)
+	return &resp, nil
+}
+
+// ReserveIdempotentResponse stores resp without its status, header and body
+// before the request is handled, replacing an expired one left for the same key.
+// It fails with ErrConflict when the key of the actor is stored since the given time.
+func (r *Repository) ReserveIdempotentResponse(ctx context.Context, resp repository.IdempotentResponse, since time.Time) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	id := responseKey{resp.Actor, resp.Key}
+	if stored, ok := r.store.data.responses[id]; ok && !stored.CreatedAt.Before(since) {
+		return newError(repository.ErrConflict, "idempotency key %v is already used", resp.Key)
+	}
+
+	resp.Status = 0
+	resp.Header = map[string]string{}
+	resp.Body = []byte{}
+	resp.CreatedAt = r.now()
+	put(r.store, r.store.data.responses, id, resp)
+
+	return nil
+}
+
+// SaveIdempotentResponse stores the status, the header and the body of the response
+// for the key reserved by ReserveIdempotentResponse.
+func (r *Repository) SaveIdempotentResponse(ctx context.Context, resp repository.IdempotentResponse) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	id := responseKey{resp.Actor, resp.Key}
+	stored, ok := r.store.data.responses[id]
+	if !ok || stored.RequestHash != resp.RequestHash {
+		return newError(repository.ErrNotFound, "idempotency key %v is not reserved", resp.Key)
+	}
+
+	resp = copyResponse(resp)
+	resp.CreatedAt = stored.CreatedAt
+	put(r.store, r.store.data.responses, id, resp)
+
+	return nil
+}
+
+// ReleaseIdempotentResponse removes the reservation of the key whose request failed,
+// so that the request can be retried with the same key.
+func (r *Repository) ReleaseIdempotentResponse(ctx context.Context, actor string, key string) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	id := responseKey{actor, key}
+	if stored, ok := r.store.data.responses[id]; ok && stored.Status == 0 {
+		remove(r.store, r.store.data.responses, id)
+	}
+
+	return nil
+}
+
+func (r *Repository) PurgeIdempotentResponses(ctx context.Context, before time.Time) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	for key, resp := range r.store.data.responses {
+		if resp.CreatedAt.Before(before) {
+			remove(r.store, r.store.data.responses, key)
+		}
+	}
+
+	return nil
+}
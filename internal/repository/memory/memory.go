@@ -0,0 +1,350 @@
+// Package memory implements repository.TodoListManipulation in memory.
+// It behaves like the SQL repository, so it serves both as a fake in tests
+// and as a storage of a server which does not need to keep its todos.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+)
+
+// Column lengths of todo.todo_list. Longer values are rejected with
+// repository.ErrInvalid like the databases do.
+const (
+	maxTitleLength       = 128
+	maxDescriptionLength = 1024
+)
+
+type todo struct {
+	id          int
+	name        string
+	description string
+	version     int
+	updatedAt   time.Time
+	deleted     bool
+	deletedAt   time.Time
+}
+
+type user struct {
+	password    [32]byte
+	sessionHash *[32]byte
+}
+
+// responseKey identifies an idempotent response, whose key belongs to an actor.
+type responseKey struct {
+	actor string
+	key   string
+}
+
+// state is everything stored in the repository.
+type state struct {
+	lastID    int
+	todos     map[int]todo
+	revisions map[int][]repository.TodoRevision
+	responses map[responseKey]repository.IdempotentResponse
+	users     map[string]user
+}
+
+func newState() state {
+	return state{
+		todos:     make(map[int]todo),
+		revisions: make(map[int][]repository.TodoRevision),
+		responses: make(map[responseKey]repository.IdempotentResponse),
+		users:     make(map[string]user),
+	}
+}
+
+// clone returns a copy of s which shares nothing mutable with it.
+func (s state) clone() state {
+	c := newState()
+	c.lastID = s.lastID
+
+	for id, todo := range s.todos {
+		c.todos[id] = todo
+	}
+	for id, revisions := range s.revisions {
+		c.revisions[id] = append([]repository.TodoRevision{}, revisions...)
+	}
+	for key, resp := range s.responses {
+		c.responses[key] = resp
+	}
+	for username, user := range s.users {
+		c.users[username] = user
+	}
+
+	return c
+}
+
+// store is shared by a repository and the repositories bound to its units of work.
+type store struct {
+	mu   sync.Mutex
+	now  func() time.Time
+	data state
+	// units is the depth of the running units of work, and undo has
+	// the functions undoing each of their changes in the order they were made.
+	units int
+	undo  []func()
+}
+
+// record keeps how to undo a change while a unit of work runs.
+func (s *store) record(undo func()) {
+	if s.units > 0 {
+		s.undo = append(s.undo, undo)
+	}
+}
+
+// rollback undoes the changes recorded since the given length of the undo log.
+func (s *store) rollback(mark int) {
+	for i := len(s.undo) - 1; i >= mark; i-- {
+		s.undo[i]()
+	}
+	s.undo = s.undo[:mark]
+}
+
+// put sets the value of key in m so that it can be undone.
+func put[K comparable, V any](s *store, m map[K]V, key K, value V) {
+	old, ok := m[key]
+	s.record(func() {
+		if ok {
+			m[key] = old
+		} else {
+			delete(m, key)
+		}
+	})
+	m[key] = value
+}
+
+// remove deletes key from m so that it can be undone.
+func remove[K comparable, V any](s *store, m map[K]V, key K) {
+	old, ok := m[key]
+	if !ok {
+		return
+	}
+	s.record(func() { m[key] = old })
+	delete(m, key)
+}
+
+// nextID gives the id of a new todo so that it can be undone.
+func (s *store) nextID() int {
+	last := s.data.lastID
+	s.record(func() { s.data.lastID = last })
+	s.data.lastID++
+
+	return s.data.lastID
+}
+
+// Repository keeps todos and users in memory. It is safe for concurrent use.
+type Repository struct {
+	store *store
+	// inUnit is true for the repository given to a unit of work,
+	// which already holds the lock of the store.
+	inUnit bool
+}
+
+func NewRepository() *Repository {
+	r := new(Repository)
+	r.store = new(store)
+	r.store.now = time.Now
+	r.store.data = newState()
+
+	return r
+}
+
+// SetClock replaces the clock giving the time of changes, for tests.
+func (r *Repository) SetClock(now func() time.Time) {
+	defer r.lock()()
+
+	r.store.now = now
+}
+
+// lock locks the store and returns the function to unlock it.
+func (r *Repository) lock() func() {
+	if r.inUnit {
+		return func() {}
+	}
+
+	r.store.mu.Lock()
+	return r.store.mu.Unlock
+}
+
+// now returns the current time as the databases store it,
+// in UTC without a fraction of a second.
+func (r *Repository) now() time.Time {
+	return r.store.now().UTC().Truncate(time.Second)
+}
+
+// Snapshot is a copy of everything stored in a repository at some point.
+type Snapshot struct {
+	data state
+}
+
+// Snapshot copies the current state of the repository.
+func (r *Repository) Snapshot() *Snapshot {
+	defer r.lock()()
+
+	return &Snapshot{data: r.store.data.clone()}
+}
+
+// Restore brings the repository back to the state of the snapshot.
+// The snapshot is left as it is, so it can be restored again.
+func (r *Repository) Restore(snapshot *Snapshot) {
+	defer r.lock()()
+
+	r.store.data = snapshot.data.clone()
+}
+
+// WithinTx runs f as a unit of work. Other callers wait until f returns,
+// and everything f did is undone when it returns an error.
+// Calling WithinTx on the given repository nests a unit, which is undone
+// alone without aborting the outer one. The error of f is returned unchanged.
+func (r *Repository) WithinTx(ctx context.Context, f func(repo repository.TodoListManipulation) error) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	bound := new(Repository)
+	bound.store = r.store
+	bound.inUnit = true
+
+	return r.atomically(func() error {
+		return f(bound)
+	})
+}
+
+// atomically runs f and undoes its changes when it fails or panics.
+// Only the changes are recorded, so the cost does not depend on the size of the store.
+// The store must be locked by the caller.
+func (r *Repository) atomically(f func() error) error {
+	s := r.store
+	mark := len(s.undo)
+	s.units++
+
+	done := false
+	defer func() {
+		s.units--
+		if !done {
+			s.rollback(mark)
+		}
+		if s.units == 0 {
+			// Nothing is undone after the outermost unit ends.
+			s.undo = nil
+		}
+	}()
+
+	if err := f(); err != nil {
+		return err
+	}
+	done = true
+
+	return nil
+}
+
+// AddUser registers a user with the SHA-256 hash of the password.
+func (r *Repository) AddUser(username string, password [32]byte) {
+	defer r.lock()()
+
+	put(r.store, r.store.data.users, username, user{password: password})
+}
+
+func (r *Repository) GetUserInfo(ctx context.Context, username string) (*repository.UserInfo, error) {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	user, ok := r.store.data.users[username]
+	if !ok {
+		return nil, newError(repository.ErrNotFound, "user %v", username)
+	}
+
+	password := user.password
+	info := repository.UserInfo{
+		Username:       username,
+		HashedPassword: &password,
+	}
+
+	return &info, nil
+}
+
+// GetSessionHash returns the session hash of the user,
+// or nil if the user has never logged in.
+func (r *Repository) GetSessionHash(ctx context.Context, username string) (*[32]byte, error) {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	user, ok := r.store.data.users[username]
+	if !ok {
+		return nil, newError(repository.ErrNotFound, "user %v", username)
+	}
+	if user.sessionHash == nil {
+		return nil, nil
+	}
+
+	hash := *user.sessionHash
+	return &hash, nil
+}
+
+// SetSessionHash does nothing for an unknown user, like the UPDATE
+// of the SQL repository which matches no row.
+func (r *Repository) SetSessionHash(ctx context.Context, username string, hash [32]byte) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	user, ok := r.store.data.users[username]
+	if !ok {
+		return nil
+	}
+
+	user.sessionHash = &hash
+	put(r.store, r.store.data.users, username, user)
+
+	return nil
+}
+
+func newError(kind error, format string, args ...any) error {
+	return &repository.Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// checkContext reports the end of ctx as the SQL repository does.
+func checkContext(ctx context.Context) error {
+	err := ctx.Err()
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return &repository.Error{Kind: repository.ErrTimeout, Err: err}
+	default:
+		return &repository.Error{Kind: repository.ErrUnavailable, Err: err}
+	}
+}
+
+// sortedTodos returns the todos matching keep ordered by less.
+func (s state) sortedTodos(keep func(todo) bool, less func(a, b todo) bool) []todo {
+	todos := []todo{}
+	for _, todo := range s.todos {
+		if keep(todo) {
+			todos = append(todos, todo)
+		}
+	}
+
+	sort.Slice(todos, func(i, j int) bool {
+		return less(todos[i], todos[j])
+	})
+
+	return todos
+}
@@ -0,0 +1,196 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/Soya-Onishi/api-server-go/internal/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ids are given in order", func(t *testing.T) {
+		rep := NewRepository()
+		assert.Equal(t, 1, rep.AddTodo("prepare hot water", ""))
+		assert.Nil(t, rep.PostTodo(ctx, repository.TodoResponse{Id: 10, Name: "eat ramen"}, "tester"))
+		assert.Nil(t, rep.DeleteTodo(ctx, 2, 0, "tester"))
+		assert.Nil(t, rep.PostTodo(ctx, repository.TodoResponse{Name: "wash the bowl"}, "tester"))
+
+		todos, err := rep.GetAllTodos(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(todos))
+		assert.Equal(t, 1, todos[0].Id)
+		assert.Equal(t, 3, todos[1].Id)
+	})
+
+	t.Run("snapshot is restored", func(t *testing.T) {
+		rep := NewRepository()
+		rep.AddTodo("prepare hot water", "")
+		rep.AddUser("Taro", [32]byte{1})
+		snapshot := rep.Snapshot()
+
+		assert.Nil(t, rep.UpdateTodo(ctx, 1, repository.TodoUpdater{Name: repository.Updatable[string]{Updatable: true, Value: "boil water"}}, "tester"))
+		assert.Nil(t, rep.SetSessionHash(ctx, "Taro", [32]byte{2}))
+		rep.AddTodo("eat ramen", "")
+
+		for i := 0; i < 2; i++ {
+			rep.Restore(snapshot)
+
+			todos, _ := rep.GetAllTodos(ctx)
+			assert.Equal(t, 1, len(todos))
+			assert.Equal(t, "prepare hot water", todos[0].Name)
+			hash, _ := rep.GetSessionHash(ctx, "Taro")
+			assert.Nil(t, hash)
+			history, _ := rep.GetTodoHistory(ctx, 1)
+			assert.Equal(t, 0, len(history))
+
+			rep.AddTodo("eat ramen", "")
+		}
+	})
+
+	t.Run("failed unit is undone", func(t *testing.T) {
+		rep := NewRepository()
+		rep.AddTodo("prepare hot water", "")
+
+		failure := errors.New("failure")
+		err := rep.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+			if err := repo.DeleteTodo(ctx, 1, 0, "tester"); err != nil {
+				return err
+			}
+
+			inner := repo.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+				repo.PostTodo(ctx, repository.TodoResponse{Name: "eat ramen"}, "tester")
+				return failure
+			})
+			assert.Equal(t, failure, inner)
+
+			todos, _ := repo.GetAllTodos(ctx)
+			assert.Equal(t, 0, len(todos))
+			trash, _ := repo.GetTrash(ctx)
+			assert.Equal(t, 1, len(trash))
+
+			return failure
+		})
+		assert.Equal(t, failure, err)
+
+		todos, _ := rep.GetAllTodos(ctx)
+		assert.Equal(t, 1, len(todos))
+		assert.Equal(t, 1, todos[0].Version)
+	})
+
+	t.Run("only the changes of a unit are recorded", func(t *testing.T) {
+		rep := NewRepository()
+		rep.AddTodo("prepare hot water", "")
+
+		err := rep.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+			repo.PostTodo(ctx, repository.TodoResponse{Name: "eat ramen"}, "tester")
+			assert.Equal(t, 3, len(rep.store.undo))
+
+			return repo.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+				repo.PostTodo(ctx, repository.TodoResponse{Name: "wash the bowl"}, "tester")
+				return nil
+			})
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, rep.store.undo)
+		assert.Equal(t, 0, rep.store.units)
+
+		todos, _ := rep.GetAllTodos(ctx)
+		assert.Equal(t, 3, len(todos))
+	})
+
+	t.Run("panicking unit is undone", func(t *testing.T) {
+		rep := NewRepository()
+		rep.AddTodo("prepare hot water", "")
+
+		assert.Panics(t, func() {
+			rep.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+				repo.PostTodo(ctx, repository.TodoResponse{Name: "eat ramen"}, "tester")
+				panic("boom")
+			})
+		})
+		assert.Nil(t, rep.store.undo)
+		assert.Equal(t, 0, rep.store.units)
+
+		assert.Nil(t, rep.PostTodo(ctx, repository.TodoResponse{Name: "wash the bowl"}, "tester"))
+		todos, _ := rep.GetAllTodos(ctx)
+		assert.Equal(t, 2, len(todos))
+		assert.Equal(t, 2, todos[1].Id)
+		history, _ := rep.GetTodoHistory(ctx, 2)
+		assert.Equal(t, 1, len(history))
+	})
+
+	t.Run("values longer than the columns are invalid", func(t *testing.T) {
+		rep := NewRepository()
+		rep.AddTodo("prepare hot water", "")
+
+		err := rep.PostTodo(ctx, repository.TodoResponse{Name: strings.Repeat("あ", 129)}, "tester")
+		assert.ErrorIs(t, err, repository.ErrInvalid)
+
+		err = rep.UpdateTodo(ctx, 1, repository.TodoUpdater{Description: repository.Updatable[string]{Updatable: true, Value: strings.Repeat("a", 1025)}}, "tester")
+		assert.ErrorIs(t, err, repository.ErrInvalid)
+
+		todo, _ := rep.GetTodo(ctx, 1)
+		assert.Equal(t, 1, todo.Version)
+	})
+
+	t.Run("expired context is reported as timeout", func(t *testing.T) {
+		rep := NewRepository()
+
+		expired, cancel := context.WithTimeout(ctx, -time.Second)
+		defer cancel()
+		_, err := rep.GetAllTodos(expired)
+		assert.ErrorIs(t, err, repository.ErrTimeout)
+	})
+
+	t.Run("concurrent updates are not lost", func(t *testing.T) {
+		rep := NewRepository()
+		rep.AddTodo("prepare hot water", "")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				rep.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+					todo, err := repo.GetTodo(ctx, 1)
+					if err != nil {
+						return err
+					}
+
+					name := repository.Updatable[string]{Updatable: true, Value: fmt.Sprintf("boil water %v", i)}
+					return repo.UpdateTodo(ctx, 1, repository.TodoUpdater{Name: name, Version: todo.Version}, "tester")
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		todo, _ := rep.GetTodo(ctx, 1)
+		assert.Equal(t, 21, todo.Version)
+		history, _ := rep.GetTodoHistory(ctx, 1)
+		assert.Equal(t, 20, len(history))
+	})
+}
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.TodoListManipulation {
+		rep := NewRepository()
+		for _, todo := range repositorytest.Todos {
+			rep.AddTodo(todo, "")
+		}
+		for _, user := range repositorytest.Users {
+			rep.AddUser(user, sha256.Sum256([]byte(user)))
+		}
+
+		return rep
+	})
+}
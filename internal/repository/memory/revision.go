@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+)
+
+// recordRevision appends a revision for the todo with its current state.
+// Nothing is recorded when the change does not modify any field.
+func (r *Repository) recordRevision(id int, action string, actor string, before *repository.TodoSnapshot) {
+	after := r.store.data.todos[id].snapshot()
+	diff := repository.DiffSnapshot(before, after)
+	if len(diff) == 0 {
+		return
+	}
+
+	revisions := r.store.data.revisions[id]
+	put(r.store, r.store.data.revisions, id, append(revisions, repository.TodoRevision{
+		TodoId:    id,
+		Rev:       len(revisions) + 1,
+		Action:    action,
+		Actor:     actor,
+		CreatedAt: r.now(),
+		Diff:      diff,
+		Snapshot:  *after,
+	}))
+}
+
+func (r *Repository) GetTodoHistory(ctx context.Context, id uint) ([]repository.TodoRevision, error) {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, ok := r.store.data.todos[int(id)]; !ok {
+		return nil, newError(repository.ErrNotFound, "todo %v", id)
+	}
+
+	revisions := []repository.TodoRevision{}
+	for _, rev := range r.store.data.revisions[int(id)] {
+		diff := make(map[string]repository.FieldChange, len(rev.Diff))
+		for field, change := range rev.Diff {
+			diff[field] = change
+		}
+		rev.Diff = diff
+
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, nil
+}
+
+// RevertTodo brings the todo back to the state recorded at revision rev,
+// recording the change as a new revision.
+func (r *Repository) RevertTodo(ctx context.Context, id uint, rev int, actor string) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	revisions := r.store.data.revisions[int(id)]
+	if rev < 1 || rev > len(revisions) {
+		return newError(repository.ErrNotFound, "revision %v of todo %v", rev, id)
+	}
+	after := revisions[rev-1].Snapshot
+
+	current, ok := r.store.data.todos[int(id)]
+	if !ok {
+		return newError(repository.ErrNotFound, "todo %v", id)
+	}
+
+	before := current.snapshot()
+	if len(repository.DiffSnapshot(before, &after)) == 0 {
+		return nil
+	}
+
+	current.name = after.Name
+	current.description = after.Description
+	if after.Deleted && !current.deleted {
+		current.deletedAt = r.now()
+	}
+	if !after.Deleted {
+		current.deletedAt = time.Time{}
+	}
+	current.deleted = after.Deleted
+
+	r.save(current)
+	r.recordRevision(int(id), repository.RevisionRevert, actor, before)
+
+	return nil
+}
@@ -0,0 +1,318 @@
+package memory
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+)
+
+func (t todo) response() repository.TodoResponse {
+	return repository.TodoResponse{
+		Id:          t.id,
+		Name:        t.name,
+		Description: t.description,
+		Version:     t.version,
+		UpdatedAt:   t.updatedAt,
+	}
+}
+
+func (t todo) snapshot() *repository.TodoSnapshot {
+	return &repository.TodoSnapshot{Name: t.name, Description: t.description, Deleted: t.deleted}
+}
+
+func validate(name string, description string) error {
+	if utf8.RuneCountInString(name) > maxTitleLength {
+		return newError(repository.ErrInvalid, "title is longer than %v characters", maxTitleLength)
+	}
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return newError(repository.ErrInvalid, "description is longer than %v characters", maxDescriptionLength)
+	}
+
+	return nil
+}
+
+// AddTodo stores a todo without recording a revision, like the rows
+// inserted into a database directly, and returns its id.
+func (r *Repository) AddTodo(name string, description string) int {
+	defer r.lock()()
+
+	return r.insertTodo(name, description)
+}
+
+func (r *Repository) insertTodo(name string, description string) int {
+	id := r.store.nextID()
+	put(r.store, r.store.data.todos, id, todo{
+		id:          id,
+		name:        name,
+		description: description,
+		version:     1,
+		updatedAt:   r.now(),
+	})
+
+	return id
+}
+
+func (r *Repository) GetAllTodos(ctx context.Context) ([]repository.TodoResponse, error) {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.allTodos(), nil
+}
+
+func (r *Repository) allTodos() []repository.TodoResponse {
+	todos := r.store.data.sortedTodos(
+		func(t todo) bool { return !t.deleted },
+		func(a, b todo) bool { return a.id < b.id },
+	)
+
+	resp := []repository.TodoResponse{}
+	for _, todo := range todos {
+		resp = append(resp, todo.response())
+	}
+
+	return resp
+}
+
+func (r *Repository) GetTodo(ctx context.Context, id uint) (*repository.TodoResponse, error) {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	todo, ok := r.store.data.todos[int(id)]
+	if !ok || todo.deleted {
+		return nil, newError(repository.ErrNotFound, "todo %v", id)
+	}
+
+	resp := todo.response()
+	return &resp, nil
+}
+
+func (r *Repository) GetTodoListMetadata(ctx context.Context) (*repository.TodoListMetadata, error) {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	// Like the SQL repository, todos in the trash are counted too.
+	var metadata repository.TodoListMetadata
+	for _, todo := range r.store.data.todos {
+		metadata.Count++
+		metadata.VersionSum += int64(todo.version)
+		if todo.id > metadata.MaxID {
+			metadata.MaxID = todo.id
+		}
+		if todo.updatedAt.After(metadata.LastModified) {
+			metadata.LastModified = todo.updatedAt
+		}
+	}
+
+	return &metadata, nil
+}
+
+func (r *Repository) SearchTodos(ctx context.Context, query string, offset int, limit int) (*repository.SearchPage, error) {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if offset < 0 || limit < 0 {
+		return nil, newError(repository.ErrInvalid, "negative offset or limit: %v, %v", offset, limit)
+	}
+
+	results := repository.RankTodos(r.allTodos(), query)
+	page := repository.SearchPage{Results: []repository.SearchResult{}, Total: len(results)}
+
+	if offset < len(results) {
+		end := len(results)
+		if limit < end-offset {
+			end = offset + limit
+		}
+		page.Results = results[offset:end]
+	}
+
+	return &page, nil
+}
+
+func (r *Repository) PostTodo(ctx context.Context, todo repository.TodoResponse, actor string) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	return r.postTodo(todo, actor)
+}
+
+func (r *Repository) DeleteTodo(ctx context.Context, id uint, version int, actor string) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	return r.deleteTodo(int(id), version, actor)
+}
+
+func (r *Repository) UpdateTodo(ctx context.Context, id int, todo repository.TodoUpdater, actor string) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	return r.updateTodo(id, todo, actor)
+}
+
+// postTodo ignores the id of the todo and gives it the next one.
+func (r *Repository) postTodo(todo repository.TodoResponse, actor string) error {
+	if err := validate(todo.Name, todo.Description); err != nil {
+		return err
+	}
+
+	id := r.insertTodo(todo.Name, todo.Description)
+	r.recordRevision(id, repository.RevisionCreate, actor, nil)
+
+	return nil
+}
+
+func (r *Repository) deleteTodo(id int, version int, actor string) error {
+	current, ok := r.store.data.todos[id]
+	if !ok || current.deleted {
+		if version != 0 {
+			return repository.ErrVersionMismatch
+		}
+
+		return nil
+	}
+	if version != 0 && current.version != version {
+		return repository.ErrVersionMismatch
+	}
+
+	before := current.snapshot()
+	current.deleted = true
+	current.deletedAt = r.now()
+	r.save(current)
+	r.recordRevision(id, repository.RevisionDelete, actor, before)
+
+	return nil
+}
+
+func (r *Repository) updateTodo(id int, updater repository.TodoUpdater, actor string) error {
+	current, ok := r.store.data.todos[id]
+	if !ok || current.deleted {
+		if updater.Version != 0 {
+			return repository.ErrVersionMismatch
+		}
+
+		return newError(repository.ErrNotFound, "todo %v does not exist", id)
+	}
+
+	if !updater.Name.Updatable && !updater.Description.Updatable {
+		return nil
+	}
+	if updater.Version != 0 && current.version != updater.Version {
+		return repository.ErrVersionMismatch
+	}
+
+	before := current.snapshot()
+	if updater.Name.Updatable {
+		current.name = updater.Name.Value
+	}
+	if updater.Description.Updatable {
+		current.description = updater.Description.Value
+	}
+	if err := validate(current.name, current.description); err != nil {
+		return err
+	}
+
+	r.save(current)
+	r.recordRevision(id, repository.RevisionUpdate, actor, before)
+
+	return nil
+}
+
+// save stores a changed todo with its version bumped.
+func (r *Repository) save(t todo) {
+	t.version++
+	t.updatedAt = r.now()
+	put(r.store, r.store.data.todos, t.id, t)
+}
+
+func (r *Repository) GetTrash(ctx context.Context) ([]repository.TrashedTodo, error) {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	todos := r.store.data.sortedTodos(
+		func(t todo) bool { return t.deleted },
+		func(a, b todo) bool {
+			if !a.deletedAt.Equal(b.deletedAt) {
+				return a.deletedAt.Before(b.deletedAt)
+			}
+
+			return a.id < b.id
+		},
+	)
+
+	resp := []repository.TrashedTodo{}
+	for _, todo := range todos {
+		resp = append(resp, repository.TrashedTodo{
+			Id:          todo.id,
+			Name:        todo.name,
+			Description: todo.description,
+			DeletedAt:   todo.deletedAt,
+		})
+	}
+
+	return resp, nil
+}
+
+func (r *Repository) RestoreTodo(ctx context.Context, id uint, actor string) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	current, ok := r.store.data.todos[int(id)]
+	if !ok || !current.deleted {
+		return newError(repository.ErrNotFound, "todo %v is not in the trash", id)
+	}
+
+	before := current.snapshot()
+	current.deleted = false
+	current.deletedAt = time.Time{}
+	r.save(current)
+	r.recordRevision(int(id), repository.RevisionRestore, actor, before)
+
+	return nil
+}
+
+// PurgeTrash removes the todos deleted before the given time
+// together with their revisions.
+func (r *Repository) PurgeTrash(ctx context.Context, before time.Time) error {
+	defer r.lock()()
+
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	for id, todo := range r.store.data.todos {
+		if todo.deleted && todo.deletedAt.Before(before) {
+			remove(r.store, r.store.data.todos, id)
+			remove(r.store, r.store.data.revisions, id)
+		}
+	}
+
+	return nil
+}
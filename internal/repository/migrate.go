@@ -0,0 +1,504 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The schema of every storage is built by the migrations in migrations/<storage>.
+// A migration is a pair of files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql. Applied migrations must not be edited;
+// the change should be made by a new migration instead.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single step of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is the SHA-256 of Up, which is recorded when the migration
+	// is applied so that an edited migration can be detected.
+	Checksum string
+}
+
+// MigrationStatus tells whether the migration is applied to the database.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrationLockTimeout is how long a migrator waits for another one to finish.
+const migrationLockTimeout = time.Minute
+
+// migrationLockName identifies the lock taken while migrating.
+// PostgreSQL takes an advisory lock of a number, which is "todo" in ASCII.
+const (
+	migrationLockName = "todo.schema_migrations"
+	migrationLockKey  = 0x746f646f
+)
+
+// migrationStorage is how migrations are run on each storage.
+type migrationStorage struct {
+	dialect *dialect
+	// bootstrap creates todo.schema_migrations, which records
+	// the applied migrations.
+	bootstrap []string
+	// split is true when the driver runs only one statement at a time.
+	split bool
+	// lock keeps other migrators out until unlock is called.
+	lock func(ctx context.Context, conn querier) (unlock func(), err error)
+}
+
+var migrationStorages = map[string]*migrationStorage{
+	"mysql": {
+		dialect: mysqlDialect,
+		bootstrap: []string{
+			"CREATE DATABASE IF NOT EXISTS todo",
+			`CREATE TABLE IF NOT EXISTS todo.schema_migrations (
+				version    INT UNSIGNED NOT NULL PRIMARY KEY,
+				name       VARCHAR(255) NOT NULL,
+				checksum   CHAR(64) NOT NULL,
+				applied_at DATETIME NOT NULL
+			)`,
+		},
+		split: true,
+		lock:  mysqlMigrationLock,
+	},
+	"sqlite": {
+		dialect: sqliteDialect,
+		bootstrap: []string{
+			`CREATE TABLE IF NOT EXISTS todo.schema_migrations (
+				version    INTEGER NOT NULL PRIMARY KEY,
+				name       VARCHAR(255) NOT NULL,
+				checksum   CHAR(64) NOT NULL,
+				applied_at DATETIME NOT NULL
+			)`,
+		},
+		// Transactions of SQLite lock the whole database,
+		// so every migration is already run by one migrator at a time.
+		lock: func(ctx context.Context, conn querier) (func(), error) {
+			return func() {}, nil
+		},
+	},
+	"postgres": {
+		dialect: postgresDialect,
+		bootstrap: []string{
+			"CREATE SCHEMA IF NOT EXISTS todo",
+			`CREATE TABLE IF NOT EXISTS todo.schema_migrations (
+				version    INTEGER NOT NULL PRIMARY KEY,
+				name       VARCHAR(255) NOT NULL,
+				checksum   CHAR(64) NOT NULL,
+				applied_at TIMESTAMPTZ(0) NOT NULL
+			)`,
+		},
+		lock: postgresMigrationLock,
+	},
+}
+
+func mysqlMigrationLock(ctx context.Context, conn querier) (func(), error) {
+	var locked sql.NullInt64
+	err := conn.QueryRowContext(
+		ctx,
+		"SELECT GET_LOCK(?, ?)",
+		migrationLockName,
+		int(migrationLockTimeout/time.Second),
+	).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+	if locked.Int64 != 1 {
+		return nil, newError(ErrTimeout, "another migration is running")
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", migrationLockName)
+	}, nil
+}
+
+func postgresMigrationLock(ctx context.Context, conn querier) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, migrationLockTimeout)
+	defer cancel()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", migrationLockKey); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(?)", migrationLockKey)
+	}, nil
+}
+
+// Migrator applies and reverts the migrations of a storage.
+type Migrator struct {
+	db         *sql.DB
+	storage    *migrationStorage
+	migrations []Migration
+}
+
+// NewMigrator returns the migrator of db, which is a database
+// of the storage mysql, sqlite or postgres.
+func NewMigrator(db *sql.DB, storage string) (*Migrator, error) {
+	s, ok := migrationStorages[storage]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for storage: %v", storage)
+	}
+
+	migrations, err := loadMigrations(path.Join("migrations", storage))
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(Migrator)
+	m.db = db
+	m.storage = s
+	m.migrations = migrations
+
+	return m, nil
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("malformed migration file name: %v", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %v has two names: %v and %v", version, migration.Name, match[2])
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+			sum := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %v needs both up and down", migration.Version)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrations returns every migration known to the migrator in order of version.
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration{}, m.migrations...)
+}
+
+// appliedMigration is a row of todo.schema_migrations.
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// open connects to the database and creates todo.schema_migrations.
+// Every query of a migration runs on the returned connection, because
+// the lock of MySQL and PostgreSQL belongs to the connection.
+func (m *Migrator) open(ctx context.Context) (*sql.Conn, querier, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, classify(err)
+	}
+
+	q := m.storage.dialect.wrap(conn)
+	for _, statement := range m.storage.bootstrap {
+		if _, err := q.ExecContext(ctx, statement); err != nil {
+			conn.Close()
+			return nil, nil, classify(err)
+		}
+	}
+
+	return conn, q, nil
+}
+
+func (m *Migrator) applied(ctx context.Context, q querier) (map[int]appliedMigration, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, checksum, applied_at FROM todo.schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var checksum string
+		var appliedAt textTime
+		if err := rows.Scan(&version, &checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedMigration{checksum: checksum, appliedAt: appliedAt.Time}
+	}
+
+	return applied, rows.Err()
+}
+
+// check reports the migrations which were edited after they were applied,
+// and the ones applied by a newer server.
+func (m *Migrator) check(applied map[int]appliedMigration) error {
+	known := map[int]bool{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+
+		if a, ok := applied[migration.Version]; ok && a.checksum != migration.Checksum {
+			return newError(ErrConflict, "migration %v_%v was edited after it was applied", migration.Version, migration.Name)
+		}
+	}
+
+	for version := range applied {
+		if !known[version] {
+			return newError(ErrConflict, "migration %v is applied but unknown to this server", version)
+		}
+	}
+
+	return nil
+}
+
+// Status returns every migration with whether it is applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	conn, q, err := m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := m.applied(ctx, q)
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	statuses := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		a, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: a.appliedAt})
+	}
+
+	return statuses, m.check(applied)
+}
+
+// Verify reports an error unless every migration is applied as it is.
+// It only reads todo.schema_migrations, so it can be run as often as
+// a readiness probe without changing the database. A database without
+// the table is reported as unavailable.
+func (m *Migrator) Verify(ctx context.Context) error {
+	applied, err := m.applied(ctx, m.storage.dialect.wrap(m.db))
+	if err != nil {
+		return newError(ErrUnavailable, "applied migrations cannot be read: %w", err)
+	}
+	if err := m.check(applied); err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+	}
+	if pending != 0 {
+		return newError(ErrUnavailable, "%v of %v migrations are not applied", pending, len(m.migrations))
+	}
+
+	return nil
+}
+
+// Up applies every migration which is not applied yet, in order of version.
+// Each migration is applied in its own transaction, so a failed one leaves
+// the database at the version before it. MySQL commits the schema changes
+// implicitly, which leaves the changes made before the failed statement.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			if err := m.apply(ctx, conn, migration, true); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down reverts the last steps migrations applied to the database.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if err := m.apply(ctx, conn, migration, false); err != nil {
+				return err
+			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// locked runs f with the migration lock held, once the applied
+// migrations are checked.
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn, applied map[int]appliedMigration) error) error {
+	conn, q, err := m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := m.storage.lock(ctx, q)
+	if err != nil {
+		return classify(err)
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx, q)
+	if err != nil {
+		return classify(err)
+	}
+	if err := m.check(applied); err != nil {
+		return err
+	}
+
+	return f(conn, applied)
+}
+
+// apply runs the up script of the migration and records it when up is true,
+// or runs the down script and removes the record otherwise.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return classify(err)
+	}
+
+	if err := m.run(ctx, tx, migration, up); err != nil {
+		tx.Rollback()
+		return newError(ErrUnavailable, "migration %v_%v: %w", migration.Version, migration.Name, err)
+	}
+
+	return classify(tx.Commit())
+}
+
+func (m *Migrator) run(ctx context.Context, tx *sql.Tx, migration Migration, up bool) error {
+	record := m.storage.dialect.wrap(tx)
+
+	// Another migrator may have run the migration
+	// while this one was waiting for the transaction.
+	var recorded int
+	err := record.QueryRowContext(ctx, "SELECT COUNT(*) FROM todo.schema_migrations WHERE version = ?", migration.Version).Scan(&recorded)
+	if err != nil {
+		return err
+	}
+	if (recorded != 0) == up {
+		return nil
+	}
+
+	script := migration.Up
+	if !up {
+		script = migration.Down
+	}
+
+	statements := []string{script}
+	if m.storage.split {
+		statements = splitStatements(script)
+	}
+
+	// Scripts are already written for the storage,
+	// so they are not rewritten by the dialect.
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	if !up {
+		_, err := record.ExecContext(ctx, "DELETE FROM todo.schema_migrations WHERE version = ?", migration.Version)
+		return err
+	}
+
+	_, err = record.ExecContext(
+		ctx,
+		"INSERT INTO todo.schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+		migration.Version,
+		migration.Name,
+		migration.Checksum,
+	)
+
+	return err
+}
+
+// splitStatements splits a script at the semicolons ending a line,
+// for drivers which run one statement at a time.
+func splitStatements(script string) []string {
+	statements := []string{}
+
+	var b strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		b.WriteString(line + "\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(b.String()), ";"))
+			b.Reset()
+		}
+	}
+
+	if rest := strings.TrimSpace(b.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+
+	return statements
+}
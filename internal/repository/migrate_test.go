@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// migrate applies every migration of the storage to db.
+func migrate(db *sql.DB, storage string) error {
+	m, err := NewMigrator(db, storage)
+	if err != nil {
+		return err
+	}
+
+	return m.Up(context.Background())
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	open := func(t *testing.T) (*sql.DB, *Migrator) {
+		db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "todo.db")+"?_txlock=immediate&_busy_timeout=5000")
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		m, err := NewMigrator(db, "sqlite")
+		if err != nil {
+			panic(err)
+		}
+
+		return db, m
+	}
+
+	t.Run("every storage has the same versions", func(t *testing.T) {
+		versions := map[string][]int{}
+		for _, storage := range []string{"mysql", "sqlite", "postgres"} {
+			m, err := NewMigrator(nil, storage)
+			assert.Nil(t, err)
+
+			for i, migration := range m.Migrations() {
+				assert.Equal(t, i+1, migration.Version)
+				assert.Len(t, migration.Checksum, 64)
+				versions[storage] = append(versions[storage], migration.Version)
+			}
+		}
+
+		assert.NotEmpty(t, versions["mysql"])
+		assert.Equal(t, versions["mysql"], versions["sqlite"])
+		assert.Equal(t, versions["mysql"], versions["postgres"])
+
+		_, err := NewMigrator(nil, "oracle")
+		assert.Error(t, err)
+	})
+
+	t.Run("up applies pending migrations once", func(t *testing.T) {
+		db, m := open(t)
+
+		assert.ErrorIs(t, m.Verify(ctx), ErrUnavailable)
+
+		assert.Nil(t, m.Up(ctx))
+		assert.Nil(t, m.Up(ctx))
+		assert.Nil(t, m.Verify(ctx))
+
+		statuses, err := m.Status(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, len(m.Migrations()), len(statuses))
+		for _, status := range statuses {
+			assert.True(t, status.Applied)
+			assert.False(t, status.AppliedAt.IsZero())
+		}
+
+		rep := NewSQLiteRepository(db)
+		assert.Nil(t, rep.PostTodo(ctx, TodoResponse{Name: "boil water"}, "tester"))
+	})
+
+	t.Run("verify does not create the migration table", func(t *testing.T) {
+		db, m := open(t)
+
+		assert.ErrorIs(t, m.Verify(ctx), ErrUnavailable)
+
+		var tables int
+		db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'").Scan(&tables)
+		assert.Equal(t, 0, tables)
+	})
+
+	t.Run("down reverts the last migrations", func(t *testing.T) {
+		db, m := open(t)
+		assert.Nil(t, m.Up(ctx))
+
+		assert.Nil(t, m.Down(ctx, 1))
+		statuses, err := m.Status(ctx)
+		assert.Nil(t, err)
+		assert.False(t, statuses[len(statuses)-1].Applied)
+		assert.True(t, statuses[len(statuses)-2].Applied)
+		assert.ErrorIs(t, m.Verify(ctx), ErrUnavailable)
+
+		assert.Nil(t, m.Down(ctx, len(m.Migrations())))
+		_, err = db.Exec("SELECT * FROM todo_list")
+		assert.Error(t, err)
+
+		assert.Nil(t, m.Up(ctx))
+		assert.Nil(t, m.Verify(ctx))
+	})
+
+	t.Run("up upgrades a database of the initial schema", func(t *testing.T) {
+		db, m := open(t)
+		assert.Nil(t, m.Up(ctx))
+		assert.Nil(t, m.Down(ctx, len(m.Migrations())-1))
+
+		_, err := db.Exec("INSERT INTO todo_list (title) VALUES ('boil water')")
+		assert.Nil(t, err)
+
+		assert.Nil(t, m.Up(ctx))
+
+		todo, err := NewSQLiteRepository(db).GetTodo(ctx, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, "boil water", todo.Name)
+		assert.Equal(t, "", todo.Description)
+		assert.Equal(t, 1, todo.Version)
+		assert.False(t, todo.UpdatedAt.IsZero())
+	})
+
+	t.Run("edited migration is detected", func(t *testing.T) {
+		db, m := open(t)
+		assert.Nil(t, m.Up(ctx))
+
+		db.Exec("UPDATE schema_migrations SET checksum = 'edited' WHERE version = 1")
+
+		assert.ErrorIs(t, m.Up(ctx), ErrConflict)
+		assert.ErrorIs(t, m.Down(ctx, 1), ErrConflict)
+		assert.ErrorIs(t, m.Verify(ctx), ErrConflict)
+	})
+
+	t.Run("migration unknown to the server is detected", func(t *testing.T) {
+		db, m := open(t)
+		assert.Nil(t, m.Up(ctx))
+
+		db.Exec("INSERT INTO schema_migrations VALUES (9999, 'future', 'checksum', CURRENT_TIMESTAMP)")
+
+		assert.ErrorIs(t, m.Up(ctx), ErrConflict)
+	})
+
+	t.Run("concurrent migrators apply each migration once", func(t *testing.T) {
+		db, m := open(t)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 4)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = m.Up(ctx)
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			assert.Nil(t, err)
+		}
+
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count)
+		assert.Equal(t, len(m.Migrations()), count)
+	})
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- comment;
+CREATE TABLE a (
+  id INT
+);
+
+DROP TABLE b;
+DROP TABLE c`
+
+	assert.Equal(t, []string{
+		"CREATE TABLE a (\n  id INT\n)",
+		"DROP TABLE b",
+		"DROP TABLE c",
+	}, splitStatements(script))
+}
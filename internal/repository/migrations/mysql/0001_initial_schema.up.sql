@@ -0,0 +1,15 @@
+-- The schema of build/db/sql before the migrations. IF NOT EXISTS lets
+-- a database made by those scripts start from this version.
+CREATE DATABASE IF NOT EXISTS todo;
+CREATE DATABASE IF NOT EXISTS auth;
+
+CREATE TABLE IF NOT EXISTS todo.todo_list (
+  id        BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  title     VARCHAR(128) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS auth.users (
+  username    VARCHAR(64) NOT NULL PRIMARY KEY,
+  passwd      VARCHAR(64) NOT NULL,
+  session_hash VARCHAR(64)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE todo.todo_list
+  DROP INDEX idx_deleted_at,
+  DROP COLUMN deleted_at;
@@ -0,0 +1,3 @@
+ALTER TABLE todo.todo_list
+  ADD COLUMN deleted_at DATETIME NULL DEFAULT NULL,
+  ADD INDEX idx_deleted_at (deleted_at);
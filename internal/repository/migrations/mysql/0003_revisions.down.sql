@@ -0,0 +1 @@
+DROP TABLE todo.todo_revision;
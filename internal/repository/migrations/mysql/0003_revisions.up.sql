@@ -0,0 +1,11 @@
+CREATE TABLE todo.todo_revision (
+  todo_id    BIGINT(20) UNSIGNED NOT NULL,
+  rev        INT UNSIGNED NOT NULL,
+  action     VARCHAR(16) NOT NULL,
+  actor      VARCHAR(64) NOT NULL,
+  created_at DATETIME NOT NULL,
+  diff       JSON NOT NULL,
+  snapshot   JSON NOT NULL,
+  PRIMARY KEY (todo_id, rev),
+  FOREIGN KEY (todo_id) REFERENCES todo.todo_list (id) ON DELETE CASCADE
+);
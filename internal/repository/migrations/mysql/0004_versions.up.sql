@@ -0,0 +1,2 @@
+ALTER TABLE todo.todo_list
+  ADD COLUMN version BIGINT(20) UNSIGNED NOT NULL DEFAULT 1;
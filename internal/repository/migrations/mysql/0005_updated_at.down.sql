@@ -0,0 +1,2 @@
+ALTER TABLE todo.todo_list
+  DROP COLUMN updated_at;
@@ -0,0 +1,2 @@
+ALTER TABLE todo.todo_list
+  ADD COLUMN updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;
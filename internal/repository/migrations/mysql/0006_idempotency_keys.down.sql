@@ -0,0 +1 @@
+DROP TABLE todo.idempotency_key;
@@ -0,0 +1,3 @@
+ALTER TABLE todo.todo_list
+  DROP INDEX ft_title_description,
+  DROP COLUMN description;
@@ -0,0 +1,5 @@
+ALTER TABLE todo.todo_list
+  ADD COLUMN description VARCHAR(1024) NOT NULL DEFAULT '' AFTER title;
+
+ALTER TABLE todo.todo_list
+  ADD FULLTEXT INDEX ft_title_description (title, description);
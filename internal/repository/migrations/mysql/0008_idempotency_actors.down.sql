@@ -0,0 +1,10 @@
+DROP TABLE todo.idempotency_key;
+
+CREATE TABLE todo.idempotency_key (
+  idem_key     VARCHAR(255) NOT NULL PRIMARY KEY,
+  request_hash CHAR(64) NOT NULL,
+  status       INT NOT NULL,
+  body         BLOB NOT NULL,
+  created_at   DATETIME NOT NULL,
+  INDEX idx_created_at (created_at)
+);
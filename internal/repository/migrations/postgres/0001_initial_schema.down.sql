@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS auth.users;
+DROP TABLE IF EXISTS todo.todo_list;
@@ -0,0 +1,15 @@
+-- The same tables as MySQL.
+
+CREATE SCHEMA IF NOT EXISTS todo;
+CREATE SCHEMA IF NOT EXISTS auth;
+
+CREATE TABLE IF NOT EXISTS todo.todo_list (
+  id          BIGSERIAL PRIMARY KEY,
+  title       VARCHAR(128) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS auth.users (
+  username     VARCHAR(64) NOT NULL PRIMARY KEY,
+  passwd       VARCHAR(64) NOT NULL,
+  session_hash VARCHAR(64)
+);
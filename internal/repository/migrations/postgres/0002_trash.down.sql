@@ -0,0 +1,2 @@
+DROP INDEX todo.idx_deleted_at;
+ALTER TABLE todo.todo_list DROP COLUMN deleted_at;
@@ -0,0 +1,3 @@
+-- Timestamps have no fraction of a second like DATETIME of MySQL.
+ALTER TABLE todo.todo_list ADD COLUMN deleted_at TIMESTAMPTZ(0) NULL DEFAULT NULL;
+CREATE INDEX idx_deleted_at ON todo.todo_list (deleted_at);
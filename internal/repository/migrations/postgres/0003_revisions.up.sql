@@ -0,0 +1,10 @@
+CREATE TABLE todo.todo_revision (
+  todo_id    BIGINT NOT NULL REFERENCES todo.todo_list (id) ON DELETE CASCADE,
+  rev        INTEGER NOT NULL,
+  action     VARCHAR(16) NOT NULL,
+  actor      VARCHAR(64) NOT NULL,
+  created_at TIMESTAMPTZ(0) NOT NULL,
+  diff       JSONB NOT NULL,
+  snapshot   JSONB NOT NULL,
+  PRIMARY KEY (todo_id, rev)
+);
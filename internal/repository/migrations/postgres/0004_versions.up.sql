@@ -0,0 +1 @@
+ALTER TABLE todo.todo_list ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+DROP TRIGGER todo_list_updated_at ON todo.todo_list;
+DROP FUNCTION todo.touch_updated_at();
+ALTER TABLE todo.todo_list DROP COLUMN updated_at;
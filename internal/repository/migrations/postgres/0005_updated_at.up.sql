@@ -0,0 +1,12 @@
+-- The trigger stands in for ON UPDATE CURRENT_TIMESTAMP.
+ALTER TABLE todo.todo_list ADD COLUMN updated_at TIMESTAMPTZ(0) NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+CREATE FUNCTION todo.touch_updated_at() RETURNS trigger AS $$
+BEGIN
+  NEW.updated_at := CURRENT_TIMESTAMP;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER todo_list_updated_at BEFORE UPDATE ON todo.todo_list
+FOR EACH ROW EXECUTE FUNCTION todo.touch_updated_at();
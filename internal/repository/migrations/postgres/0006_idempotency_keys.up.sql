@@ -0,0 +1,8 @@
+CREATE TABLE todo.idempotency_key (
+  idem_key     VARCHAR(255) NOT NULL PRIMARY KEY,
+  request_hash CHAR(64) NOT NULL,
+  status       INTEGER NOT NULL,
+  body         BYTEA NOT NULL,
+  created_at   TIMESTAMPTZ(0) NOT NULL
+);
+CREATE INDEX idx_created_at ON todo.idempotency_key (created_at);
@@ -0,0 +1 @@
+ALTER TABLE todo.todo_list DROP COLUMN description;
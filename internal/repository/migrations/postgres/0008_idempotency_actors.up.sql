@@ -0,0 +1,16 @@
+-- Keys belong to the actor who sent them, and a row is reserved with status 0
+-- before the request is handled. The stored responses do not tell their actors,
+-- so they are dropped.
+DROP TABLE todo.idempotency_key;
+
+CREATE TABLE todo.idempotency_key (
+  actor        VARCHAR(64) NOT NULL,
+  idem_key     VARCHAR(255) NOT NULL,
+  request_hash CHAR(64) NOT NULL,
+  status       INTEGER NOT NULL,
+  header       TEXT NOT NULL,
+  body         BYTEA NOT NULL,
+  created_at   TIMESTAMPTZ(0) NOT NULL,
+  PRIMARY KEY (actor, idem_key)
+);
+CREATE INDEX idx_created_at ON todo.idempotency_key (created_at);
@@ -0,0 +1,13 @@
+-- The same tables as MySQL without the schema names. The CHECK constraints
+-- stand in for the column lengths which SQLite does not enforce.
+
+CREATE TABLE IF NOT EXISTS todo_list (
+  id          INTEGER PRIMARY KEY AUTOINCREMENT,
+  title       VARCHAR(128) NOT NULL CHECK (length(title) <= 128)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+  username     VARCHAR(64) NOT NULL PRIMARY KEY,
+  passwd       VARCHAR(64) NOT NULL,
+  session_hash VARCHAR(64)
+);
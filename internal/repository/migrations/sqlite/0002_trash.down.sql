@@ -0,0 +1,2 @@
+DROP INDEX idx_deleted_at;
+ALTER TABLE todo_list DROP COLUMN deleted_at;
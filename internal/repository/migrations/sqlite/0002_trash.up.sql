@@ -0,0 +1,2 @@
+ALTER TABLE todo_list ADD COLUMN deleted_at DATETIME NULL DEFAULT NULL;
+CREATE INDEX idx_deleted_at ON todo_list (deleted_at);
@@ -0,0 +1,11 @@
+CREATE TABLE todo_revision (
+  todo_id    INTEGER NOT NULL,
+  rev        INTEGER NOT NULL,
+  action     VARCHAR(16) NOT NULL,
+  actor      VARCHAR(64) NOT NULL,
+  created_at DATETIME NOT NULL,
+  diff       TEXT NOT NULL,
+  snapshot   TEXT NOT NULL,
+  PRIMARY KEY (todo_id, rev),
+  FOREIGN KEY (todo_id) REFERENCES todo_list (id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+DROP TRIGGER todo_list_updated_at;
+DROP TRIGGER todo_list_created_at;
+ALTER TABLE todo_list DROP COLUMN updated_at;
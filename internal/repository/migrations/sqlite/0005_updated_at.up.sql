@@ -0,0 +1,16 @@
+-- SQLite cannot add a column defaulting to CURRENT_TIMESTAMP, so the triggers
+-- set it instead. They also stand in for ON UPDATE CURRENT_TIMESTAMP.
+ALTER TABLE todo_list ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';
+UPDATE todo_list SET updated_at = CURRENT_TIMESTAMP;
+
+CREATE TRIGGER todo_list_created_at AFTER INSERT ON todo_list
+FOR EACH ROW
+BEGIN
+  UPDATE todo_list SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER todo_list_updated_at AFTER UPDATE ON todo_list
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+  UPDATE todo_list SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
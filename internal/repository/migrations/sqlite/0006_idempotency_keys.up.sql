@@ -0,0 +1,8 @@
+CREATE TABLE idempotency_key (
+  idem_key     VARCHAR(255) NOT NULL PRIMARY KEY,
+  request_hash CHAR(64) NOT NULL,
+  status       INTEGER NOT NULL,
+  body         BLOB NOT NULL,
+  created_at   DATETIME NOT NULL
+);
+CREATE INDEX idx_created_at ON idempotency_key (created_at);
@@ -0,0 +1 @@
+ALTER TABLE todo_list DROP COLUMN description;
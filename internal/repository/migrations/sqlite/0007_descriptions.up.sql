@@ -0,0 +1 @@
+ALTER TABLE todo_list ADD COLUMN description VARCHAR(1024) NOT NULL DEFAULT '' CHECK (length(description) <= 1024);
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresPlaceholders numbers the ? placeholders as $1, $2, ...
+// Question marks in string literals are left as they are.
+func postgresPlaceholders(query string) string {
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+var postgresDialect = &dialect{
+	name:      "postgres",
+	rewrite:   postgresPlaceholders,
+	arg:       func(v any) any { return v },
+	fullText:  false,
+	returning: true,
+}
+
+// OpenPostgres connects to the PostgreSQL database of dsn,
+// whose tables are created by Migrator.
+func OpenPostgres(dsn string) (*sql.DB, error) {
+	return sql.Open("postgres", dsn)
+}
+
+// NewPostgresRepository returns a repository storing todos
+// in a PostgreSQL database opened by OpenPostgres.
+func NewPostgresRepository(db *sql.DB) *Repository {
+	r := NewRepository(db)
+	r.dialect = postgresDialect
+
+	return r
+}
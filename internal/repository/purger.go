@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/logging"
+)
+
+// Purger periodically removes todos which have stayed in the trash
+// longer than the retention period, and idempotent responses older
+// than the idempotency window.
+type Purger struct {
+	repo              TodoListManipulation
+	retention         time.Duration
+	idempotencyWindow time.Duration
+	interval          time.Duration
+	logger            *logging.Logger
+	cancel            context.CancelFunc
+	done              chan struct{}
+}
+
+func NewPurger(repo TodoListManipulation, retention time.Duration, idempotencyWindow time.Duration, interval time.Duration) *Purger {
+	p := new(Purger)
+	p.repo = repo
+	p.retention = retention
+	p.idempotencyWindow = idempotencyWindow
+	p.interval = interval
+	p.logger = logging.Default()
+	p.done = make(chan struct{})
+
+	return p
+}
+
+// SetLogger sets the logger of failed purges.
+func (p *Purger) SetLogger(logger *logging.Logger) {
+	p.logger = logger
+}
+
+// Start runs the purge loop in a new goroutine until Stop is called.
+func (p *Purger) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			p.Purge(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the purge loop and waits for it to exit.
+// A purge in progress is canceled.
+func (p *Purger) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+// Purge removes every todo deleted before now minus the retention period
+// and every idempotent response which is out of the window.
+// A purge canceled through ctx is not logged as a failure.
+func (p *Purger) Purge(ctx context.Context) error {
+	if err := p.repo.PurgeTrash(ctx, time.Now().Add(-p.retention)); err != nil {
+		if ctx.Err() == nil {
+			p.logger.Error("failed to purge trash", "error", err)
+		}
+
+		return err
+	}
+
+	err := p.repo.PurgeIdempotentResponses(ctx, time.Now().Add(-p.idempotencyWindow))
+	if err != nil && ctx.Err() == nil {
+		p.logger.Error("failed to purge idempotent responses", "error", err)
+	}
+
+	return err
+}
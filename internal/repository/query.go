@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var errEmptyUpdate = errors.New("update has no column to set")
+
+// updateQuery builds a parameterized UPDATE statement.
+// Values are always passed as placeholders, so only the table, column
+// and condition names, which come from the code, end up in the SQL text.
+type updateQuery struct {
+	table    string
+	sets     []string
+	setArgs  []any
+	conds    []string
+	condArgs []any
+}
+
+func newUpdateQuery(table string) *updateQuery {
+	q := new(updateQuery)
+	q.table = table
+
+	return q
+}
+
+// set assigns value to column.
+func (q *updateQuery) set(column string, value any) *updateQuery {
+	q.sets = append(q.sets, column+" = ?")
+	q.setArgs = append(q.setArgs, value)
+
+	return q
+}
+
+// setExpr assigns an SQL expression such as "version = version + 1".
+// The expression must not contain values from requests.
+func (q *updateQuery) setExpr(expr string, args ...any) *updateQuery {
+	q.sets = append(q.sets, expr)
+	q.setArgs = append(q.setArgs, args...)
+
+	return q
+}
+
+// where adds a condition joined with AND.
+func (q *updateQuery) where(cond string, args ...any) *updateQuery {
+	q.conds = append(q.conds, cond)
+	q.condArgs = append(q.condArgs, args...)
+
+	return q
+}
+
+// setUpdatable sets column only when u is updatable.
+// It is a function because methods cannot have type parameters.
+func setUpdatable[T any](q *updateQuery, column string, u Updatable[T]) *updateQuery {
+	if u.Updatable {
+		q.set(column, u.Value)
+	}
+
+	return q
+}
+
+// empty reports whether no column is set.
+func (q *updateQuery) empty() bool {
+	return len(q.sets) == 0
+}
+
+// build returns the statement and its arguments.
+func (q *updateQuery) build() (string, []any, error) {
+	if q.empty() {
+		return "", nil, errEmptyUpdate
+	}
+
+	query := "UPDATE " + q.table + " SET " + strings.Join(q.sets, ", ")
+	if len(q.conds) != 0 {
+		query += " WHERE " + strings.Join(q.conds, " AND ")
+	}
+
+	args := append(append([]any{}, q.setArgs...), q.condArgs...)
+
+	return query, args, nil
+}
+
+// exec runs the statement and returns the number of affected rows.
+func (q *updateQuery) exec(ctx context.Context, tx querier) (int64, error) {
+	query, args, err := q.build()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
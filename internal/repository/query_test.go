@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateQuery(t *testing.T) {
+	t.Run("only updatable fields are set", func(t *testing.T) {
+		query := newUpdateQuery("todo.todo_list")
+		setUpdatable(query, "title", Updatable[string]{Updatable: true, Value: "it's"})
+		setUpdatable(query, "description", Updatable[string]{Updatable: false, Value: "ignored"})
+		query.setExpr("version = version + 1").where("id = ?", 1).where("version = ?", 2)
+
+		sql, args, err := query.build()
+		assert.Nil(t, err)
+		assert.Equal(t, "UPDATE todo.todo_list SET title = ?, version = version + 1 WHERE id = ? AND version = ?", sql)
+		assert.Equal(t, []any{"it's", 1, 2}, args)
+	})
+
+	t.Run("nothing to set", func(t *testing.T) {
+		query := newUpdateQuery("todo.todo_list")
+		setUpdatable(query, "title", Updatable[string]{})
+
+		assert.True(t, query.empty())
+		_, _, err := query.build()
+		assert.ErrorIs(t, err, errEmptyUpdate)
+	})
+
+	t.Run("affected rows are returned", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE todo.user_list SET session_hash = \\? WHERE username = \\?").
+			WithArgs("hash", "Taro").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		tx, err := db.Begin()
+		assert.Nil(t, err)
+		defer tx.Rollback()
+
+		updated, err := newUpdateQuery("todo.user_list").
+			set("session_hash", "hash").
+			where("username = ?", "Taro").
+			exec(context.Background(), mysqlDialect.wrap(tx))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), updated)
+	})
+}
+
+func TestPostgresPlaceholders(t *testing.T) {
+	query := postgresPlaceholders("UPDATE todo.todo_list SET title = ? WHERE description = 'why?' AND id = ?")
+	assert.Equal(t, "UPDATE todo.todo_list SET title = $1 WHERE description = 'why?' AND id = $2", query)
+}
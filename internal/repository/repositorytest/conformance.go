@@ -0,0 +1,712 @@
+// Package repositorytest checks that an implementation of
+// repository.TodoListManipulation behaves like the SQL repository.
+package repositorytest
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Soya-Onishi/api-server-go/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+// Todos are the todos every repository given to the suite must start with.
+// They have ids 1, 2 and 3 in this order, version 1 and no revisions.
+var Todos = []string{"prepare hot water", "wait for three minutes", "eat ramen"}
+
+// Users are the users every repository given to the suite must start with.
+// The password of each user is the username, and no one has logged in yet.
+var Users = []string{"Taro", "Hanako", "Ryota"}
+
+// Factory returns a new repository holding only Todos and Users.
+// It is called for every test, and should release the repository
+// with t.Cleanup.
+type Factory func(t *testing.T) repository.TodoListManipulation
+
+// Run runs the behavioural contract of repository.TodoListManipulation
+// against the repositories made by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("todos", func(t *testing.T) { testTodos(t, factory) })
+	t.Run("versions", func(t *testing.T) { testVersions(t, factory) })
+	t.Run("trash", func(t *testing.T) { testTrash(t, factory) })
+	t.Run("search", func(t *testing.T) { testSearch(t, factory) })
+	t.Run("history", func(t *testing.T) { testHistory(t, factory) })
+	t.Run("idempotency", func(t *testing.T) { testIdempotency(t, factory) })
+	t.Run("users", func(t *testing.T) { testUsers(t, factory) })
+	t.Run("unit of work", func(t *testing.T) { testUnitOfWork(t, factory) })
+	t.Run("concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+}
+
+var ctx = context.Background()
+
+func rename(name string) repository.TodoUpdater {
+	return repository.TodoUpdater{Name: repository.Updatable[string]{Updatable: true, Value: name}}
+}
+
+func names(todos []repository.TodoResponse) []string {
+	names := []string{}
+	for _, todo := range todos {
+		names = append(names, todo.Name)
+	}
+
+	return names
+}
+
+func allTodos(t *testing.T, rep repository.TodoListManipulation) []repository.TodoResponse {
+	todos, err := rep.GetAllTodos(ctx)
+	assert.Nil(t, err)
+
+	return todos
+}
+
+func testTodos(t *testing.T, factory Factory) {
+	t.Run("todos are listed in order of id", func(t *testing.T) {
+		rep := factory(t)
+
+		todos := allTodos(t, rep)
+		assert.Equal(t, Todos, names(todos))
+		for i, todo := range todos {
+			assert.Equal(t, i+1, todo.Id)
+			assert.Equal(t, "", todo.Description)
+			assert.Equal(t, 1, todo.Version)
+			assert.False(t, todo.UpdatedAt.IsZero())
+		}
+	})
+
+	t.Run("todo is got by id", func(t *testing.T) {
+		rep := factory(t)
+
+		todo, err := rep.GetTodo(ctx, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, todo.Id)
+		assert.Equal(t, Todos[1], todo.Name)
+
+		todo, err = rep.GetTodo(ctx, 4)
+		assert.Nil(t, todo)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("posted todo gets a new id", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.Nil(t, rep.PostTodo(ctx, repository.TodoResponse{Id: 1, Name: "power on", Description: "at 9:00"}, "tester"))
+		assert.Nil(t, rep.PostTodo(ctx, repository.TodoResponse{Id: 1, Name: "erase directory"}, "tester"))
+
+		todos := allTodos(t, rep)
+		assert.Equal(t, append(append([]string{}, Todos...), "power on", "erase directory"), names(todos))
+		assert.Greater(t, todos[3].Id, len(Todos))
+		assert.Greater(t, todos[4].Id, todos[3].Id)
+		assert.Equal(t, "at 9:00", todos[3].Description)
+		assert.Equal(t, 1, todos[3].Version)
+
+		todo, err := rep.GetTodo(ctx, uint(todos[3].Id))
+		assert.Nil(t, err)
+		assert.Equal(t, todos[3], *todo)
+	})
+
+	t.Run("only updatable fields are updated", func(t *testing.T) {
+		rep := factory(t)
+
+		update := repository.TodoUpdater{Description: repository.Updatable[string]{Updatable: true, Value: `it's "hot"`}}
+		assert.Nil(t, rep.UpdateTodo(ctx, 1, update, "tester"))
+
+		todo, _ := rep.GetTodo(ctx, 1)
+		assert.Equal(t, Todos[0], todo.Name)
+		assert.Equal(t, `it's "hot"`, todo.Description)
+		assert.Equal(t, 2, todo.Version)
+
+		assert.Nil(t, rep.UpdateTodo(ctx, 1, rename(""), "tester"))
+		todo, _ = rep.GetTodo(ctx, 1)
+		assert.Equal(t, "", todo.Name)
+	})
+
+	t.Run("update without fields has no effect", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.Nil(t, rep.UpdateTodo(ctx, 1, repository.TodoUpdater{}, "tester"))
+
+		todo, _ := rep.GetTodo(ctx, 1)
+		assert.Equal(t, 1, todo.Version)
+	})
+
+	t.Run("update of unknown todo is not found", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.ErrorIs(t, rep.UpdateTodo(ctx, 4, rename("boil water"), "tester"), repository.ErrNotFound)
+
+		rep.DeleteTodo(ctx, 1, 0, "tester")
+		assert.ErrorIs(t, rep.UpdateTodo(ctx, 1, rename("boil water"), "tester"), repository.ErrNotFound)
+	})
+
+	t.Run("deleted todo is not listed", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.Nil(t, rep.DeleteTodo(ctx, 1, 0, "tester"))
+		assert.Equal(t, Todos[1:], names(allTodos(t, rep)))
+
+		todo, err := rep.GetTodo(ctx, 1)
+		assert.Nil(t, todo)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("delete of unknown todo is ignored", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.Nil(t, rep.DeleteTodo(ctx, 4, 0, "tester"))
+		assert.Equal(t, Todos, names(allTodos(t, rep)))
+	})
+
+	t.Run("values longer than the columns are invalid", func(t *testing.T) {
+		rep := factory(t)
+
+		err := rep.PostTodo(ctx, repository.TodoResponse{Name: strings.Repeat("a", 129)}, "tester")
+		assert.ErrorIs(t, err, repository.ErrInvalid)
+
+		err = rep.PostTodo(ctx, repository.TodoResponse{Name: "power on", Description: strings.Repeat("a", 1025)}, "tester")
+		assert.ErrorIs(t, err, repository.ErrInvalid)
+
+		assert.Equal(t, Todos, names(allTodos(t, rep)))
+	})
+}
+
+func testVersions(t *testing.T, factory Factory) {
+	t.Run("every write increments version", func(t *testing.T) {
+		rep := factory(t)
+
+		update := rename("title updated")
+		update.Version = 1
+		assert.Nil(t, rep.UpdateTodo(ctx, 1, update, "tester"))
+		assert.Nil(t, rep.DeleteTodo(ctx, 1, 2, "tester"))
+		assert.Nil(t, rep.RestoreTodo(ctx, 1, "tester"))
+
+		todo, _ := rep.GetTodo(ctx, 1)
+		assert.Equal(t, 4, todo.Version)
+	})
+
+	t.Run("stale version is a mismatch", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.Nil(t, rep.UpdateTodo(ctx, 1, rename("title updated"), "tester"))
+
+		update := rename("conflict")
+		update.Version = 1
+		assert.ErrorIs(t, rep.UpdateTodo(ctx, 1, update, "tester"), repository.ErrVersionMismatch)
+		assert.ErrorIs(t, rep.UpdateTodo(ctx, 4, update, "tester"), repository.ErrVersionMismatch)
+		assert.ErrorIs(t, rep.DeleteTodo(ctx, 1, 1, "tester"), repository.ErrVersionMismatch)
+		assert.ErrorIs(t, rep.DeleteTodo(ctx, 4, 1, "tester"), repository.ErrVersionMismatch)
+		assert.ErrorIs(t, repository.ErrVersionMismatch, repository.ErrConflict)
+
+		todo, _ := rep.GetTodo(ctx, 1)
+		assert.Equal(t, "title updated", todo.Name)
+		assert.Equal(t, 2, todo.Version)
+	})
+
+	t.Run("metadata covers every todo", func(t *testing.T) {
+		rep := factory(t)
+
+		before, err := rep.GetTodoListMetadata(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, len(Todos), before.Count)
+		assert.Equal(t, int64(len(Todos)), before.VersionSum)
+		assert.False(t, before.LastModified.IsZero())
+
+		rep.DeleteTodo(ctx, 1, 0, "tester")
+
+		after, err := rep.GetTodoListMetadata(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, before.Count, after.Count)
+		assert.Equal(t, before.VersionSum+1, after.VersionSum)
+		assert.Equal(t, before.MaxID, after.MaxID)
+		assert.False(t, after.LastModified.Before(before.LastModified))
+	})
+
+	t.Run("metadata changes when a todo replaces a purged one", func(t *testing.T) {
+		rep := factory(t)
+
+		before, err := rep.GetTodoListMetadata(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, len(Todos), before.MaxID)
+
+		assert.Nil(t, rep.DeleteTodo(ctx, 1, 0, "tester"))
+		assert.Nil(t, rep.PurgeTrash(ctx, time.Now().Add(time.Hour)))
+		assert.Nil(t, rep.PostTodo(ctx, repository.TodoResponse{Name: "boil water"}, "tester"))
+
+		after, err := rep.GetTodoListMetadata(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, before.Count, after.Count)
+		assert.Equal(t, before.VersionSum, after.VersionSum)
+		assert.Greater(t, after.MaxID, before.MaxID)
+	})
+}
+
+func testTrash(t *testing.T, factory Factory) {
+	t.Run("deleted todos are in the trash in order of deletion", func(t *testing.T) {
+		rep := factory(t)
+
+		rep.DeleteTodo(ctx, 3, 0, "tester")
+		rep.DeleteTodo(ctx, 1, 0, "tester")
+
+		trash, err := rep.GetTrash(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(trash))
+		assert.True(t, sort.SliceIsSorted(trash, func(i, j int) bool {
+			if !trash[i].DeletedAt.Equal(trash[j].DeletedAt) {
+				return trash[i].DeletedAt.Before(trash[j].DeletedAt)
+			}
+
+			return trash[i].Id < trash[j].Id
+		}))
+		for _, todo := range trash {
+			assert.Equal(t, Todos[todo.Id-1], todo.Name)
+			assert.WithinDuration(t, time.Now(), todo.DeletedAt, time.Minute)
+		}
+	})
+
+	t.Run("restored todo is listed again", func(t *testing.T) {
+		rep := factory(t)
+
+		rep.DeleteTodo(ctx, 2, 0, "tester")
+		assert.Nil(t, rep.RestoreTodo(ctx, 2, "tester"))
+
+		assert.Equal(t, Todos, names(allTodos(t, rep)))
+		trash, _ := rep.GetTrash(ctx)
+		assert.Equal(t, 0, len(trash))
+	})
+
+	t.Run("todo not in the trash is not restored", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.ErrorIs(t, rep.RestoreTodo(ctx, 1, "tester"), repository.ErrNotFound)
+		assert.ErrorIs(t, rep.RestoreTodo(ctx, 4, "tester"), repository.ErrNotFound)
+	})
+
+	t.Run("purge removes todos deleted before the given time", func(t *testing.T) {
+		rep := factory(t)
+
+		rep.DeleteTodo(ctx, 1, 0, "tester")
+		trash, _ := rep.GetTrash(ctx)
+		deletedAt := trash[0].DeletedAt
+
+		assert.Nil(t, rep.PurgeTrash(ctx, deletedAt))
+		trash, _ = rep.GetTrash(ctx)
+		assert.Equal(t, 1, len(trash))
+
+		assert.Nil(t, rep.PurgeTrash(ctx, deletedAt.Add(time.Second)))
+		trash, _ = rep.GetTrash(ctx)
+		assert.Equal(t, 0, len(trash))
+
+		_, err := rep.GetTodoHistory(ctx, 1)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		assert.Equal(t, Todos[1:], names(allTodos(t, rep)))
+	})
+}
+
+// testSearch only uses the initial todos, because
+// the full-text index of InnoDB reflects only committed rows.
+func testSearch(t *testing.T, factory Factory) {
+	t.Run("todos are found by word", func(t *testing.T) {
+		rep := factory(t)
+
+		page, err := rep.SearchTodos(ctx, "RAMEN", 0, 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, page.Total)
+		assert.Equal(t, 1, len(page.Results))
+		assert.Equal(t, 3, page.Results[0].Todo.Id)
+		assert.Greater(t, page.Results[0].Score, 0.0)
+	})
+
+	t.Run("results are paginated", func(t *testing.T) {
+		rep := factory(t)
+
+		page, err := rep.SearchTodos(ctx, "water ramen minutes", 2, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, page.Total)
+		assert.Equal(t, 1, len(page.Results))
+
+		page, err = rep.SearchTodos(ctx, "water ramen minutes", 3, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, page.Total)
+		assert.Equal(t, 0, len(page.Results))
+
+		page, err = rep.SearchTodos(ctx, "water ramen minutes", 1, math.MaxInt)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(page.Results))
+	})
+
+	t.Run("negative offset is invalid", func(t *testing.T) {
+		rep := factory(t)
+
+		_, err := rep.SearchTodos(ctx, "ramen", -20, 20)
+		assert.ErrorIs(t, err, repository.ErrInvalid)
+	})
+
+	t.Run("nothing is found by unknown or deleted word", func(t *testing.T) {
+		rep := factory(t)
+
+		rep.DeleteTodo(ctx, 3, 0, "tester")
+
+		for _, query := range []string{"ramen", "coffee", ""} {
+			page, err := rep.SearchTodos(ctx, query, 0, 10)
+			assert.Nil(t, err)
+			assert.Equal(t, 0, page.Total, query)
+			assert.NotNil(t, page.Results, query)
+		}
+	})
+}
+
+func testHistory(t *testing.T, factory Factory) {
+	t.Run("create, update and delete are recorded", func(t *testing.T) {
+		rep := factory(t)
+
+		rep.PostTodo(ctx, repository.TodoResponse{Name: "power on"}, "tester")
+		todos := allTodos(t, rep)
+		id := todos[len(todos)-1].Id
+
+		rep.UpdateTodo(ctx, id, rename("power off"), "tester")
+		rep.UpdateTodo(ctx, id, rename("power off"), "tester")
+		rep.DeleteTodo(ctx, uint(id), 0, "another")
+
+		revisions, err := rep.GetTodoHistory(ctx, uint(id))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(revisions))
+
+		assert.Equal(t, repository.RevisionCreate, revisions[0].Action)
+		assert.Equal(t, map[string]repository.FieldChange{
+			"name":        {Old: nil, New: "power on"},
+			"description": {Old: nil, New: ""},
+			"deleted":     {Old: nil, New: false},
+		}, revisions[0].Diff)
+
+		assert.Equal(t, repository.RevisionUpdate, revisions[1].Action)
+		assert.Equal(t, "tester", revisions[1].Actor)
+		assert.Equal(t, map[string]repository.FieldChange{"name": {Old: "power on", New: "power off"}}, revisions[1].Diff)
+
+		assert.Equal(t, repository.RevisionDelete, revisions[2].Action)
+		assert.Equal(t, "another", revisions[2].Actor)
+		assert.Equal(t, repository.TodoSnapshot{Name: "power off", Deleted: true}, revisions[2].Snapshot)
+
+		for i, rev := range revisions {
+			assert.Equal(t, id, rev.TodoId)
+			assert.Equal(t, i+1, rev.Rev)
+			assert.WithinDuration(t, time.Now(), rev.CreatedAt, time.Minute)
+		}
+	})
+
+	t.Run("initial todo has empty history", func(t *testing.T) {
+		rep := factory(t)
+
+		revisions, err := rep.GetTodoHistory(ctx, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(revisions))
+	})
+
+	t.Run("history of unknown todo is not found", func(t *testing.T) {
+		rep := factory(t)
+
+		revisions, err := rep.GetTodoHistory(ctx, 4)
+		assert.Nil(t, revisions)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("revert brings back the recorded state", func(t *testing.T) {
+		rep := factory(t)
+
+		rep.UpdateTodo(ctx, 1, rename("boil water"), "tester")
+		rep.UpdateTodo(ctx, 1, rename("boil milk"), "tester")
+		rep.DeleteTodo(ctx, 1, 0, "tester")
+
+		assert.Nil(t, rep.RevertTodo(ctx, 1, 1, "reverter"))
+
+		todo, err := rep.GetTodo(ctx, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, "boil water", todo.Name)
+		assert.Equal(t, 5, todo.Version)
+
+		revisions, _ := rep.GetTodoHistory(ctx, 1)
+		assert.Equal(t, 4, len(revisions))
+		assert.Equal(t, repository.RevisionRevert, revisions[3].Action)
+		assert.Equal(t, "reverter", revisions[3].Actor)
+		assert.Equal(t, repository.FieldChange{Old: true, New: false}, revisions[3].Diff["deleted"])
+		assert.Equal(t, repository.TodoSnapshot{Name: "boil water"}, revisions[3].Snapshot)
+
+		assert.Nil(t, rep.RevertTodo(ctx, 1, 1, "reverter"))
+		revisions, _ = rep.GetTodoHistory(ctx, 1)
+		assert.Equal(t, 4, len(revisions))
+	})
+
+	t.Run("revert to unknown revision is not found", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.ErrorIs(t, rep.RevertTodo(ctx, 1, 1, "tester"), repository.ErrNotFound)
+		assert.ErrorIs(t, rep.RevertTodo(ctx, 4, 1, "tester"), repository.ErrNotFound)
+	})
+}
+
+func testIdempotency(t *testing.T, factory Factory) {
+	since := time.Now().Add(-time.Hour)
+	request := func(actor string, key string) repository.IdempotentResponse {
+		return repository.IdempotentResponse{Actor: actor, Key: key, RequestHash: fmt.Sprintf("hash of %v", key)}
+	}
+	save := func(rep repository.TodoListManipulation, actor string, key string, status int) {
+		resp := request(actor, key)
+		assert.Nil(t, rep.ReserveIdempotentResponse(ctx, resp, since))
+
+		resp.Status = status
+		resp.Header = map[string]string{"Location": "/todos/4"}
+		resp.Body = []byte(`{"id":"4"}`)
+		assert.Nil(t, rep.SaveIdempotentResponse(ctx, resp))
+	}
+
+	t.Run("saved response is got by key of the actor", func(t *testing.T) {
+		rep := factory(t)
+
+		save(rep, "Taro", "key-1", http.StatusOK)
+
+		resp, err := rep.GetIdempotentResponse(ctx, "Taro", "key-1", since)
+		assert.Nil(t, err)
+		assert.Equal(t, "Taro", resp.Actor)
+		assert.Equal(t, "key-1", resp.Key)
+		assert.Equal(t, "hash of key-1", resp.RequestHash)
+		assert.Equal(t, http.StatusOK, resp.Status)
+		assert.Equal(t, map[string]string{"Location": "/todos/4"}, resp.Header)
+		assert.Equal(t, []byte(`{"id":"4"}`), resp.Body)
+		assert.WithinDuration(t, time.Now(), resp.CreatedAt, time.Minute)
+
+		resp, err = rep.GetIdempotentResponse(ctx, "Taro", "key-2", since)
+		assert.Nil(t, resp)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		_, err = rep.GetIdempotentResponse(ctx, "Hanako", "key-1", since)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("reserved key is taken until it is released", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.Nil(t, rep.ReserveIdempotentResponse(ctx, request("Taro", "key-1"), since))
+		resp, err := rep.GetIdempotentResponse(ctx, "Taro", "key-1", since)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, resp.Status)
+
+		assert.ErrorIs(t, rep.ReserveIdempotentResponse(ctx, request("Taro", "key-1"), since), repository.ErrConflict)
+		assert.Nil(t, rep.ReserveIdempotentResponse(ctx, request("Hanako", "key-1"), since))
+
+		assert.Nil(t, rep.ReleaseIdempotentResponse(ctx, "Taro", "key-1"))
+		assert.Nil(t, rep.ReserveIdempotentResponse(ctx, request("Taro", "key-1"), since))
+	})
+
+	t.Run("saved response is not released", func(t *testing.T) {
+		rep := factory(t)
+
+		save(rep, "Taro", "key-1", http.StatusCreated)
+		assert.Nil(t, rep.ReleaseIdempotentResponse(ctx, "Taro", "key-1"))
+
+		resp, err := rep.GetIdempotentResponse(ctx, "Taro", "key-1", since)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, resp.Status)
+		assert.ErrorIs(t, rep.ReserveIdempotentResponse(ctx, request("Taro", "key-1"), since), repository.ErrConflict)
+	})
+
+	t.Run("response of a key not reserved is not saved", func(t *testing.T) {
+		rep := factory(t)
+
+		resp := request("Taro", "key-1")
+		resp.Status = http.StatusOK
+		assert.ErrorIs(t, rep.SaveIdempotentResponse(ctx, resp), repository.ErrNotFound)
+	})
+
+	t.Run("old response is ignored, replaced and purged", func(t *testing.T) {
+		rep := factory(t)
+
+		save(rep, "Taro", "key-1", http.StatusOK)
+		save(rep, "Taro", "key-2", http.StatusOK)
+
+		_, err := rep.GetIdempotentResponse(ctx, "Taro", "key-1", time.Now().Add(time.Hour))
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		assert.Nil(t, rep.ReserveIdempotentResponse(ctx, request("Taro", "key-1"), time.Now().Add(time.Hour)))
+		resp, err := rep.GetIdempotentResponse(ctx, "Taro", "key-1", since)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, resp.Status)
+
+		assert.Nil(t, rep.PurgeIdempotentResponses(ctx, since))
+		_, err = rep.GetIdempotentResponse(ctx, "Taro", "key-2", since)
+		assert.Nil(t, err)
+
+		assert.Nil(t, rep.PurgeIdempotentResponses(ctx, time.Now().Add(time.Hour)))
+		_, err = rep.GetIdempotentResponse(ctx, "Taro", "key-2", since)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+}
+
+func testUsers(t *testing.T, factory Factory) {
+	t.Run("user info has the hashed password", func(t *testing.T) {
+		rep := factory(t)
+
+		for _, username := range Users {
+			info, err := rep.GetUserInfo(ctx, username)
+			assert.Nil(t, err)
+			assert.Equal(t, username, info.Username)
+			assert.Equal(t, sha256.Sum256([]byte(username)), *info.HashedPassword)
+		}
+
+		info, err := rep.GetUserInfo(ctx, "Unknown")
+		assert.Nil(t, info)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("session hash is set per user", func(t *testing.T) {
+		rep := factory(t)
+
+		hash, err := rep.GetSessionHash(ctx, Users[0])
+		assert.Nil(t, err)
+		assert.Nil(t, hash)
+
+		session := sha256.Sum256([]byte("session"))
+		assert.Nil(t, rep.SetSessionHash(ctx, Users[0], session))
+
+		hash, err = rep.GetSessionHash(ctx, Users[0])
+		assert.Nil(t, err)
+		assert.Equal(t, session, *hash)
+
+		hash, err = rep.GetSessionHash(ctx, Users[1])
+		assert.Nil(t, err)
+		assert.Nil(t, hash)
+	})
+
+	t.Run("session hash of unknown user is not found", func(t *testing.T) {
+		rep := factory(t)
+
+		assert.Nil(t, rep.SetSessionHash(ctx, "Unknown", sha256.Sum256([]byte("session"))))
+
+		hash, err := rep.GetSessionHash(ctx, "Unknown")
+		assert.Nil(t, hash)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+}
+
+func testUnitOfWork(t *testing.T, factory Factory) {
+	t.Run("changes are kept when the unit succeeds", func(t *testing.T) {
+		rep := factory(t)
+
+		err := rep.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+			if err := repo.UpdateTodo(ctx, 1, rename("boil water"), "tester"); err != nil {
+				return err
+			}
+
+			return repo.DeleteTodo(ctx, 2, 0, "tester")
+		})
+		assert.Nil(t, err)
+
+		assert.Equal(t, []string{"boil water", Todos[2]}, names(allTodos(t, rep)))
+	})
+
+	t.Run("changes are undone when the unit fails", func(t *testing.T) {
+		rep := factory(t)
+
+		failure := errors.New("failure")
+		err := rep.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+			repo.UpdateTodo(ctx, 1, rename("boil water"), "tester")
+			repo.PostTodo(ctx, repository.TodoResponse{Name: "power on"}, "tester")
+
+			todos, err := repo.GetAllTodos(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, 4, len(todos))
+
+			return failure
+		})
+		assert.Equal(t, failure, err)
+
+		assert.Equal(t, Todos, names(allTodos(t, rep)))
+		revisions, _ := rep.GetTodoHistory(ctx, 1)
+		assert.Equal(t, 0, len(revisions))
+	})
+
+	t.Run("failed inner unit is undone alone", func(t *testing.T) {
+		rep := factory(t)
+
+		failure := errors.New("failure")
+		err := rep.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+			repo.UpdateTodo(ctx, 1, rename("boil water"), "tester")
+
+			inner := repo.WithinTx(ctx, func(repo repository.TodoListManipulation) error {
+				repo.DeleteTodo(ctx, 2, 0, "tester")
+				return failure
+			})
+			assert.Equal(t, failure, inner)
+
+			return nil
+		})
+		assert.Nil(t, err)
+
+		assert.Equal(t, []string{"boil water", Todos[1], Todos[2]}, names(allTodos(t, rep)))
+	})
+}
+
+func testConcurrency(t *testing.T, factory Factory) {
+	t.Run("concurrent posts get distinct ids", func(t *testing.T) {
+		rep := factory(t)
+
+		const posts = 10
+		var wg sync.WaitGroup
+		errs := make([]error, posts)
+		for i := 0; i < posts; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = rep.PostTodo(ctx, repository.TodoResponse{Name: fmt.Sprintf("todo %v", i)}, "tester")
+				if errs[i] == nil {
+					_, errs[i] = rep.GetAllTodos(ctx)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			assert.Nil(t, err)
+		}
+
+		todos := allTodos(t, rep)
+		assert.Equal(t, len(Todos)+posts, len(todos))
+
+		ids := map[int]bool{}
+		for _, todo := range todos {
+			ids[todo.Id] = true
+		}
+		assert.Equal(t, len(todos), len(ids))
+	})
+
+	t.Run("concurrent updates are all recorded", func(t *testing.T) {
+		rep := factory(t)
+
+		const updates = 10
+		var wg sync.WaitGroup
+		errs := make([]error, updates)
+		for i := 0; i < updates; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = rep.UpdateTodo(ctx, 1, rename(fmt.Sprintf("boil water %v", i)), "tester")
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			assert.Nil(t, err)
+		}
+
+		todo, _ := rep.GetTodo(ctx, 1)
+		assert.Equal(t, 1+updates, todo.Version)
+		revisions, _ := rep.GetTodoHistory(ctx, 1)
+		assert.Equal(t, updates, len(revisions))
+	})
+}
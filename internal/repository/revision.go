@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+)
+
+// TodoSnapshot is the state of a todo right after a revision was applied.
+type TodoSnapshot struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Deleted     bool   `json:"deleted"`
+}
+
+// FieldChange holds the old and new value of a single field.
+// Old is nil for the first revision of a todo.
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+type TodoRevision struct {
+	TodoId    int
+	Rev       int
+	Action    string
+	Actor     string
+	CreatedAt time.Time
+	Diff      map[string]FieldChange
+	Snapshot  TodoSnapshot
+}
+
+// DiffSnapshot returns the fields whose value differ between before and after.
+// A nil before means the todo did not exist yet.
+func DiffSnapshot(before *TodoSnapshot, after *TodoSnapshot) map[string]FieldChange {
+	diff := map[string]FieldChange{}
+
+	if before == nil {
+		diff["name"] = FieldChange{Old: nil, New: after.Name}
+		diff["description"] = FieldChange{Old: nil, New: after.Description}
+		diff["deleted"] = FieldChange{Old: nil, New: after.Deleted}
+
+		return diff
+	}
+
+	if before.Name != after.Name {
+		diff["name"] = FieldChange{Old: before.Name, New: after.Name}
+	}
+	if before.Description != after.Description {
+		diff["description"] = FieldChange{Old: before.Description, New: after.Description}
+	}
+	if before.Deleted != after.Deleted {
+		diff["deleted"] = FieldChange{Old: before.Deleted, New: after.Deleted}
+	}
+
+	return diff
+}
+
+// selectSnapshot locks the todo row and returns its current state,
+// or nil if the todo does not exist.
+func selectSnapshot(ctx context.Context, tx querier, id int64) (*TodoSnapshot, error) {
+	var snapshot TodoSnapshot
+	var deletedAt sql.NullTime
+
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT title, description, deleted_at FROM todo.todo_list WHERE id = ? FOR UPDATE",
+		id,
+	).Scan(&snapshot.Name, &snapshot.Description, &deletedAt)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	snapshot.Deleted = deletedAt.Valid
+
+	return &snapshot, nil
+}
+
+// recordRevision appends a revision for the todo. Nothing is recorded
+// when the change does not modify any field.
+func recordRevision(ctx context.Context, tx querier, id int64, action string, actor string, before *TodoSnapshot, after *TodoSnapshot) error {
+	changes := DiffSnapshot(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	diff, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+
+	// The todo row is locked by selectSnapshot, so no one else
+	// can take the same revision number.
+	var rev int
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT COALESCE(MAX(rev), 0) + 1 FROM todo.todo_revision WHERE todo_id = ?",
+		id,
+	).Scan(&rev)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO todo.todo_revision (todo_id, rev, action, actor, created_at, diff, snapshot)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?)`,
+		id,
+		rev,
+		action,
+		actor,
+		string(diff),
+		string(snapshot),
+	)
+
+	return err
+}
+
+func (r *Repository) GetTodoHistory(ctx context.Context, id uint) ([]TodoRevision, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	rows, err := r.conn().QueryContext(
+		ctx,
+		`SELECT rev, action, actor, created_at, diff, snapshot
+		FROM todo.todo_revision WHERE todo_id = ? ORDER BY rev`,
+		id,
+	)
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	revisions := []TodoRevision{}
+	for rows.Next() {
+		var rev TodoRevision
+		var diff, snapshot []byte
+
+		err := rows.Scan(&rev.Rev, &rev.Action, &rev.Actor, &rev.CreatedAt, &diff, &snapshot)
+		if err == nil {
+			err = json.Unmarshal(diff, &rev.Diff)
+		}
+		if err == nil {
+			err = json.Unmarshal(snapshot, &rev.Snapshot)
+		}
+		if err != nil {
+			return nil, classify(err)
+		}
+
+		rev.TodoId = int(id)
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classify(err)
+	}
+
+	if len(revisions) == 0 {
+		var exists int
+		err := r.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM todo.todo_list WHERE id = ?", id).Scan(&exists)
+		if err != nil {
+			return nil, classify(err)
+		}
+		if exists == 0 {
+			return nil, newError(ErrNotFound, "todo %v", id)
+		}
+	}
+
+	return revisions, nil
+}
+
+// RevertTodo brings the todo back to the state recorded at revision rev,
+// recording the change as a new revision.
+func (r *Repository) RevertTodo(ctx context.Context, id uint, rev int, actor string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	return r.beginTx(ctx, func(tx querier) error {
+		var snapshot []byte
+		err := tx.QueryRowContext(
+			ctx,
+			"SELECT snapshot FROM todo.todo_revision WHERE todo_id = ? AND rev = ?",
+			id,
+			rev,
+		).Scan(&snapshot)
+
+		switch {
+		case err == sql.ErrNoRows:
+			return newError(ErrNotFound, "revision %v of todo %v", rev, id)
+		case err != nil:
+			return err
+		}
+
+		var after TodoSnapshot
+		if err := json.Unmarshal(snapshot, &after); err != nil {
+			return err
+		}
+
+		before, err := selectSnapshot(ctx, tx, int64(id))
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return newError(ErrNotFound, "todo %v", id)
+		}
+		if len(DiffSnapshot(before, &after)) == 0 {
+			return nil
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			`UPDATE todo.todo_list
+			SET title = ?,
+				description = ?,
+				deleted_at = CASE WHEN ? THEN COALESCE(deleted_at, CURRENT_TIMESTAMP) ELSE NULL END,
+				version = version + 1
+			WHERE id = ?`,
+			after.Name,
+			after.Description,
+			after.Deleted,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(ctx, tx, int64(id), RevisionRevert, actor, before, &after)
+	})
+}
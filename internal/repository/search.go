@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// minTokenLength and stopwords follow the defaults of the InnoDB full-text
+// parser so that RankTodos finds the same todos as MySQL does.
+const minTokenLength = 3
+
+var stopwords = map[string]bool{
+	"a": true, "about": true, "an": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "com": true, "de": true, "en": true, "for": true,
+	"from": true, "how": true, "i": true, "in": true, "is": true, "it": true,
+	"la": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true, "where": true,
+	"who": true, "will": true, "with": true, "und": true, "www": true,
+}
+
+type SearchResult struct {
+	Todo  TodoResponse
+	Score float64
+}
+
+type SearchPage struct {
+	Results []SearchResult
+	Total   int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func isToken(word string) bool {
+	return len([]rune(word)) >= minTokenLength && !stopwords[word]
+}
+
+// Tokenize splits text into lower cased words, dropping short words and stopwords.
+func Tokenize(text string) []string {
+	tokens := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r)
+	}) {
+		if isToken(word) {
+			tokens = append(tokens, word)
+		}
+	}
+
+	return tokens
+}
+
+// RankTodos is the full-text search for backends without a full-text index.
+// Like InnoDB, a todo scores the sum of TF * IDF * IDF over the query terms,
+// and todos which score zero are not matched.
+func RankTodos(todos []TodoResponse, query string) []SearchResult {
+	terms := map[string]bool{}
+	for _, term := range Tokenize(query) {
+		terms[term] = true
+	}
+
+	frequencies := make([]map[string]int, len(todos))
+	documents := map[string]int{}
+	for i, todo := range todos {
+		frequencies[i] = map[string]int{}
+		for _, token := range Tokenize(todo.Name + " " + todo.Description) {
+			if terms[token] {
+				if frequencies[i][token] == 0 {
+					documents[token]++
+				}
+				frequencies[i][token]++
+			}
+		}
+	}
+
+	results := []SearchResult{}
+	for i, todo := range todos {
+		score := 0.0
+		for term, tf := range frequencies[i] {
+			idf := math.Log10(float64(len(todos)) / float64(documents[term]))
+			score += float64(tf) * idf * idf
+		}
+
+		if score > 0 {
+			results = append(results, SearchResult{Todo: todo, Score: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+
+		return results[i].Todo.Id < results[j].Todo.Id
+	})
+
+	return results
+}
+
+// Highlight wraps every word of text which is one of the query terms with <mark> tags.
+// The text itself is HTML-escaped so that only the tags are markup.
+func Highlight(text string, query string) string {
+	terms := map[string]bool{}
+	for _, term := range Tokenize(query) {
+		terms[term] = true
+	}
+
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			b.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(runes) && isWordRune(runes[j]) {
+			j++
+		}
+
+		word := string(runes[i:j])
+		if terms[strings.ToLower(word)] {
+			b.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		i = j
+	}
+
+	return b.String()
+}
+
+func (r *Repository) SearchTodos(ctx context.Context, query string, offset int, limit int) (*SearchPage, error) {
+	if offset < 0 || limit < 0 {
+		return nil, newError(ErrInvalid, "negative offset or limit: %v, %v", offset, limit)
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	if !r.dialect.fullText {
+		return r.rankAllTodos(ctx, query, offset, limit)
+	}
+
+	page := SearchPage{Results: []SearchResult{}}
+
+	err := r.conn().QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM todo.todo_list
+		WHERE deleted_at IS NULL AND MATCH(title, description) AGAINST(? IN NATURAL LANGUAGE MODE)`,
+		query,
+	).Scan(&page.Total)
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	rows, err := r.conn().QueryContext(
+		ctx,
+		`SELECT id, title, description, version, updated_at,
+			MATCH(title, description) AGAINST(? IN NATURAL LANGUAGE MODE) AS score
+		FROM todo.todo_list
+		WHERE deleted_at IS NULL AND MATCH(title, description) AGAINST(? IN NATURAL LANGUAGE MODE)
+		ORDER BY score DESC, id
+		LIMIT ? OFFSET ?`,
+		query,
+		query,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result SearchResult
+		todo := &result.Todo
+
+		err := rows.Scan(&todo.Id, &todo.Name, &todo.Description, &todo.Version, &todo.UpdatedAt, &result.Score)
+		if err != nil {
+			return nil, classify(err)
+		}
+
+		page.Results = append(page.Results, result)
+	}
+
+	return &page, classify(rows.Err())
+}
+
+// rankAllTodos searches by ranking every todo with RankTodos,
+// for databases without a full-text index.
+func (r *Repository) rankAllTodos(ctx context.Context, query string, offset int, limit int) (*SearchPage, error) {
+	todos, err := r.GetAllTodos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := RankTodos(todos, query)
+	page := SearchPage{Results: []SearchResult{}, Total: len(results)}
+	if offset < len(results) {
+		end := len(results)
+		if limit < end-offset {
+			end = offset + limit
+		}
+		page.Results = results[offset:end]
+	}
+
+	return &page, nil
+}
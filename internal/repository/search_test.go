@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"boil", "water", "kettle"}, Tokenize("Boil the water, in a KETTLE!"))
+	assert.Equal(t, []string{"お湯を沸かす"}, Tokenize("お湯を沸かす"))
+	assert.Equal(t, []string{}, Tokenize("to be or"))
+}
+
+func TestRankTodos(t *testing.T) {
+	todos := []TodoResponse{
+		{Id: 1, Name: "prepare hot water", Description: "use the kettle"},
+		{Id: 2, Name: "wait for three minutes"},
+		{Id: 3, Name: "eat ramen", Description: "ramen with hot water"},
+		{Id: 4, Name: "wash the bowl", Description: "with water"},
+	}
+
+	t.Run("ranked by relevance", func(t *testing.T) {
+		results := RankTodos(todos, "ramen water")
+
+		assert.Equal(t, 3, len(results))
+		assert.Equal(t, 3, results[0].Todo.Id)
+		assert.Equal(t, 1, results[1].Todo.Id)
+		assert.Equal(t, 4, results[2].Todo.Id)
+		assert.Greater(t, results[0].Score, results[1].Score)
+		assert.Equal(t, results[1].Score, results[2].Score)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		assert.Equal(t, 0, len(RankTodos(todos, "coffee")))
+		assert.Equal(t, 0, len(RankTodos(todos, "the")))
+	})
+
+	t.Run("term in every todo does not match", func(t *testing.T) {
+		assert.Equal(t, 0, len(RankTodos(todos[:1], "water")))
+	})
+}
+
+func TestHighlight(t *testing.T) {
+	assert.Equal(
+		t,
+		"prepare hot <mark>Water</mark> for <mark>ramen</mark>",
+		Highlight("prepare hot Water for ramen", "water RAMEN"),
+	)
+	assert.Equal(t, "wait for three minutes", Highlight("wait for three minutes", "water"))
+	assert.Equal(t, "", Highlight("", "water"))
+	assert.Equal(
+		t,
+		"&lt;script&gt;<mark>alert</mark>(1)&lt;/script&gt; &amp; &#34;tea&#34;",
+		Highlight(`<script>alert(1)</script> & "tea"`, "alert"),
+	)
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteTimeLayout is the format of CURRENT_TIMESTAMP in SQLite.
+// Times are stored in it so that they are compared correctly as text.
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+var sqliteDialect = &dialect{
+	name: "sqlite",
+	// SQLite has neither schemas nor row locks. Transactions are begun
+	// with BEGIN IMMEDIATE instead, which locks the whole database.
+	rewrite: strings.NewReplacer("todo.", "", "auth.", "", " FOR UPDATE", "").Replace,
+	arg: func(v any) any {
+		if t, ok := v.(time.Time); ok {
+			return t.UTC().Format(sqliteTimeLayout)
+		}
+
+		return v
+	},
+	fullText: false,
+}
+
+// OpenSQLite opens the SQLite database file at path, whose tables are
+// created by Migrator. ":memory:" opens a database which lives only
+// as long as the returned *sql.DB.
+func OpenSQLite(path string) (*sql.DB, error) {
+	params := "_foreign_keys=1&_busy_timeout=5000&_txlock=immediate"
+	if path != ":memory:" {
+		params += "&_journal_mode=WAL"
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%v?%v", path, params))
+	if err != nil {
+		return nil, err
+	}
+
+	if path == ":memory:" {
+		// Every connection to :memory: has its own database.
+		db.SetMaxOpenConns(1)
+	}
+
+	return db, nil
+}
+
+// NewSQLiteRepository returns a repository storing todos
+// in a SQLite database opened by OpenSQLite.
+func NewSQLiteRepository(db *sql.DB) *Repository {
+	r := NewRepository(db)
+	r.dialect = sqliteDialect
+
+	return r
+}
@@ -0,0 +1,38 @@
+//go:build cgo
+
+package repository
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteErrorKind returns the kind of an error from SQLite,
+// or nil if err is not a constraint violation of SQLite.
+func sqliteErrorKind(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return nil
+	}
+
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return ErrConflict
+	case sqlite3.ErrConstraintNotNull, sqlite3.ErrConstraintCheck, sqlite3.ErrConstraintForeignKey:
+		return ErrInvalid
+	}
+
+	return nil
+}
+
+// sqliteBusy reports whether SQLite failed because the database was locked
+// by another transaction.
+func sqliteBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
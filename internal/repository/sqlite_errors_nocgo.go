@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package repository
+
+// SQLite is not available without cgo, so there is no SQLite error to classify.
+
+func sqliteErrorKind(err error) error {
+	return nil
+}
+
+func sqliteBusy(err error) bool {
+	return false
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openMigrated opens the SQLite database at path with every migration applied.
+func openMigrated(path string) (*sql.DB, error) {
+	db, err := OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db, "sqlite"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func TestSQLite(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("todos are kept in the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "todo.db")
+
+		db, err := openMigrated(path)
+		assert.Nil(t, err)
+		rep := NewSQLiteRepository(db)
+		assert.Nil(t, rep.PostTodo(ctx, TodoResponse{Name: "boil water", Description: "use the kettle"}, "tester"))
+		db.Close()
+
+		db, err = openMigrated(path)
+		assert.Nil(t, err)
+		defer db.Close()
+		rep = NewSQLiteRepository(db)
+
+		todos, err := rep.GetAllTodos(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(todos))
+		assert.Equal(t, "boil water", todos[0].Name)
+		assert.Equal(t, "use the kettle", todos[0].Description)
+	})
+
+	t.Run("constraints are reported as invalid or conflict", func(t *testing.T) {
+		db, err := openMigrated(":memory:")
+		assert.Nil(t, err)
+		defer db.Close()
+		rep := NewSQLiteRepository(db)
+
+		err = rep.PostTodo(ctx, TodoResponse{Name: strings.Repeat("a", 129)}, "tester")
+		assert.ErrorIs(t, err, ErrInvalid)
+
+		_, err = db.Exec("INSERT INTO users (username, passwd) VALUES ('Taro', 'x'), ('Taro', 'y')")
+		assert.ErrorIs(t, classify(err), ErrConflict)
+
+		todos, _ := rep.GetAllTodos(ctx)
+		assert.Equal(t, 0, len(todos))
+	})
+
+	t.Run("update bumps updated_at", func(t *testing.T) {
+		db, err := openMigrated(":memory:")
+		assert.Nil(t, err)
+		defer db.Close()
+		rep := NewSQLiteRepository(db)
+
+		assert.Nil(t, rep.PostTodo(ctx, TodoResponse{Name: "boil water"}, "tester"))
+		db.Exec("UPDATE todo_list SET updated_at = '2000-01-01 00:00:00'")
+
+		before, err := rep.GetTodoListMetadata(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), before.LastModified)
+
+		assert.Nil(t, rep.UpdateTodo(ctx, 1, TodoUpdater{Name: Updatable[string]{Updatable: true, Value: "make tea"}}, "tester"))
+
+		after, err := rep.GetTodoListMetadata(ctx)
+		assert.Nil(t, err)
+		assert.WithinDuration(t, time.Now(), after.LastModified, time.Minute)
+
+		todo, err := rep.GetTodo(ctx, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, todo.Version)
+		assert.Equal(t, after.LastModified, todo.UpdatedAt)
+	})
+
+	t.Run("times are compared in UTC", func(t *testing.T) {
+		db, err := openMigrated(":memory:")
+		assert.Nil(t, err)
+		defer db.Close()
+		rep := NewSQLiteRepository(db)
+
+		assert.Nil(t, rep.PostTodo(ctx, TodoResponse{Name: "boil water"}, "tester"))
+		assert.Nil(t, rep.DeleteTodo(ctx, 1, 0, "tester"))
+
+		tokyo := time.FixedZone("JST", 9*60*60)
+		assert.Nil(t, rep.PurgeTrash(ctx, time.Now().Add(-time.Hour).In(tokyo)))
+		trash, _ := rep.GetTrash(ctx)
+		assert.Equal(t, 1, len(trash))
+
+		assert.Nil(t, rep.PurgeTrash(ctx, time.Now().Add(time.Hour).In(tokyo)))
+		trash, _ = rep.GetTrash(ctx)
+		assert.Equal(t, 0, len(trash))
+	})
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts bounds how long each kind of operation may run on the database.
+// The deadline is added to the caller's context, so the query is also
+// canceled when the caller gives up earlier. Zero means no deadline.
+type Timeouts struct {
+	// Read is for reading todos, the trash, revisions and users.
+	Read time.Duration
+	// Search is for full-text search.
+	Search time.Duration
+	// Write is for a change of a single todo, response or session.
+	Write time.Duration
+	// Batch is for a whole batch of operations and a unit of work.
+	Batch time.Duration
+	// Purge is for removing old todos and idempotent responses.
+	Purge time.Duration
+}
+
+func (r *Repository) SetTimeouts(timeouts Timeouts) {
+	r.timeouts = timeouts
+}
+
+// withTimeout returns ctx bounded by timeout.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeouts(t *testing.T) {
+	const slow = time.Second
+
+	newMock := func() (*Repository, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			panic(err)
+		}
+
+		return NewRepository(db), mock
+	}
+
+	t.Run("slow query is canceled at the deadline", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+		rep.SetTimeouts(Timeouts{Read: 10 * time.Millisecond})
+
+		mock.ExpectQuery("SELECT").WillDelayFor(slow).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		start := time.Now()
+		_, err := rep.GetAllTodos(context.Background())
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), slow)
+	})
+
+	t.Run("canceled request cancels the query", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT").WillDelayFor(slow).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		start := time.Now()
+		err := rep.UpdateTodo(ctx, 1, TodoUpdater{Name: Updatable[string]{Updatable: true, Value: "tea"}}, "tester")
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), slow)
+	})
+
+	t.Run("zero timeout keeps the caller's deadline", func(t *testing.T) {
+		ctx, cancel := withTimeout(context.Background(), 0)
+		defer cancel()
+
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+
+		ctx, cancel = withTimeout(ctx, time.Minute)
+		defer cancel()
+
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+	})
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// Errors after which the whole transaction is retried.
+const (
+	mysqlLockWaitTimeout = 1205
+	mysqlDeadlock        = 1213
+
+	postgresSerializationFailure = "40001"
+	postgresDeadlock             = "40P01"
+)
+
+const (
+	maxTxAttempts = 3
+	txRetryDelay  = 10 * time.Millisecond
+)
+
+// sqlConn is what *sql.DB and *sql.Tx have in common.
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// querier runs queries written for MySQL on the database of the dialect.
+type querier interface {
+	sqlConn
+	// InsertContext runs an INSERT and returns the id of the new row.
+	InsertContext(ctx context.Context, query string, args ...any) (int64, error)
+}
+
+// conn returns the transaction the repository is bound to, or the database.
+func (r *Repository) conn() querier {
+	if r.tx != nil {
+		return r.tx
+	}
+
+	return r.dialect.wrap(r.db)
+}
+
+// WithinTx runs f as a unit of work. Everything f does through the given
+// repository is committed together when f returns nil, and rolled back otherwise.
+// Calling WithinTx on the given repository nests a unit with a savepoint,
+// so a failing inner unit is rolled back without aborting the outer one.
+// A transaction which fails by a deadlock is retried from the beginning,
+// so f must not have side effects other than through the repository.
+// The error returned by f is returned unchanged, wrapped only when
+// the savepoint of a nested unit could not be rolled back to.
+func (r *Repository) WithinTx(ctx context.Context, f func(repo TodoListManipulation) error) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Batch)
+	defer cancel()
+
+	var failure error
+	err := r.beginTx(ctx, func(tx querier) error {
+		failure = f(r.bind(tx))
+		return failure
+	})
+	if failure != nil {
+		var rollbackErr *savepointError
+		if errors.As(err, &rollbackErr) {
+			return rollbackErr
+		}
+
+		// Errors of f are returned as they are because they may not
+		// come from the storage at all.
+		return failure
+	}
+
+	return err
+}
+
+// bind returns a copy of the repository which runs every query in tx.
+func (r *Repository) bind(tx querier) *Repository {
+	bound := new(Repository)
+	bound.db = r.db
+	bound.dialect = r.dialect
+	bound.timeouts = r.timeouts
+	bound.logger = r.logger
+	bound.tx = tx
+	bound.depth = r.depth + 1
+
+	return bound
+}
+
+// beginTx runs f in a transaction. The transaction is rolled back
+// when f fails and committed otherwise. A repository bound to
+// a transaction runs f in a savepoint of it instead.
+func (r *Repository) beginTx(ctx context.Context, f func(tx querier) error) error {
+	if r.tx != nil {
+		return classify(r.savepoint(ctx, f))
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = r.runTx(ctx, f)
+		if attempt == maxTxAttempts || !retryable(err) {
+			break
+		}
+		r.log(ctx).Warn("transaction conflicted and is retried", "attempt", attempt, "error", err)
+
+		select {
+		case <-time.After(time.Duration(attempt) * txRetryDelay):
+		case <-ctx.Done():
+			return classify(ctx.Err())
+		}
+	}
+
+	return classify(err)
+}
+
+func (r *Repository) runTx(ctx context.Context, f func(tx querier) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := f(r.dialect.wrap(tx)); err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) savepoint(ctx context.Context, f func(tx querier) error) error {
+	tx := r.conn()
+
+	name := fmt.Sprintf("unit_%d", r.depth)
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rollbackErr != nil {
+			return &savepointError{Err: err, Rollback: rollbackErr, name: name}
+		}
+
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// savepointError is the failure of a unit whose savepoint could not be
+// rolled back to. It unwraps to Err, so the kind of the failure is kept.
+type savepointError struct {
+	Err      error
+	Rollback error
+	name     string
+}
+
+func (e *savepointError) Error() string {
+	return fmt.Sprintf("%v (rolling back to savepoint %v failed: %v)", e.Err, e.name, e.Rollback)
+}
+
+func (e *savepointError) Unwrap() error {
+	return e.Err
+}
+
+// retryable reports whether the transaction failed only because
+// it conflicted with another one.
+func retryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDeadlock || mysqlErr.Number == mysqlLockWaitTimeout
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == postgresSerializationFailure || pqErr.Code == postgresDeadlock
+	}
+
+	return sqliteBusy(err)
+}
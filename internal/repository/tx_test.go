@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithinTx(t *testing.T) {
+	ctx := context.Background()
+	before := time.Now()
+
+	newMock := func() (*Repository, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			panic(err)
+		}
+
+		return NewRepository(db), mock
+	}
+
+	expectPurge := func(mock sqlmock.Sqlmock, depth string, err error) {
+		mock.ExpectExec("^SAVEPOINT unit_" + depth + "$").WillReturnResult(sqlmock.NewResult(0, 0))
+		exec := mock.ExpectExec("^DELETE FROM todo.todo_list")
+		if err != nil {
+			exec.WillReturnError(err)
+			mock.ExpectExec("^ROLLBACK TO SAVEPOINT unit_" + depth + "$").WillReturnResult(sqlmock.NewResult(0, 0))
+			return
+		}
+
+		exec.WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("^RELEASE SAVEPOINT unit_" + depth + "$").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	t.Run("operations are committed together", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+
+		mock.ExpectBegin()
+		expectPurge(mock, "1", nil)
+		expectPurge(mock, "1", nil)
+		mock.ExpectCommit()
+
+		err := rep.WithinTx(ctx, func(repo TodoListManipulation) error {
+			if err := repo.PurgeTrash(ctx, before); err != nil {
+				return err
+			}
+
+			return repo.PurgeTrash(ctx, before)
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failure rolls back every operation", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+
+		mock.ExpectBegin()
+		expectPurge(mock, "1", nil)
+		mock.ExpectRollback()
+
+		failure := errors.New("failure")
+		err := rep.WithinTx(ctx, func(repo TodoListManipulation) error {
+			if err := repo.PurgeTrash(ctx, before); err != nil {
+				return err
+			}
+
+			return failure
+		})
+		assert.Equal(t, failure, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("nested unit is rolled back to its savepoint", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("^SAVEPOINT unit_1$").WillReturnResult(sqlmock.NewResult(0, 0))
+		expectPurge(mock, "2", &mysql.MySQLError{Number: mysqlNoReferenced})
+		mock.ExpectExec("^ROLLBACK TO SAVEPOINT unit_1$").WillReturnResult(sqlmock.NewResult(0, 0))
+		expectPurge(mock, "1", nil)
+		mock.ExpectCommit()
+
+		err := rep.WithinTx(ctx, func(repo TodoListManipulation) error {
+			err := repo.WithinTx(ctx, func(inner TodoListManipulation) error {
+				return inner.PurgeTrash(ctx, before)
+			})
+			assert.ErrorIs(t, err, ErrInvalid)
+
+			return repo.PurgeTrash(ctx, before)
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed rollback to the savepoint is reported", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+
+		rejected := errors.New("rejected")
+		mock.ExpectBegin()
+		mock.ExpectExec("^SAVEPOINT unit_1$").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("^ROLLBACK TO SAVEPOINT unit_1$").WillReturnError(errors.New("connection lost"))
+		mock.ExpectRollback()
+
+		err := rep.WithinTx(ctx, func(repo TodoListManipulation) error {
+			err := repo.WithinTx(ctx, func(inner TodoListManipulation) error {
+				return rejected
+			})
+			assert.ErrorIs(t, err, rejected)
+			assert.Contains(t, err.Error(), "connection lost")
+
+			return err
+		})
+		assert.ErrorIs(t, err, rejected)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("deadlock is retried", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+
+		mock.ExpectBegin()
+		expectPurge(mock, "1", &mysql.MySQLError{Number: mysqlDeadlock})
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		expectPurge(mock, "1", nil)
+		mock.ExpectCommit()
+
+		attempts := 0
+		err := rep.WithinTx(ctx, func(repo TodoListManipulation) error {
+			attempts++
+			return repo.PurgeTrash(ctx, before)
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, attempts)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+
+		mock.ExpectBegin()
+		expectPurge(mock, "1", &mysql.MySQLError{Number: mysqlDuplicateEntry})
+		mock.ExpectRollback()
+
+		attempts := 0
+		err := rep.WithinTx(ctx, func(repo TodoListManipulation) error {
+			attempts++
+			return repo.PurgeTrash(ctx, before)
+		})
+		assert.ErrorIs(t, err, ErrConflict)
+		assert.Equal(t, 1, attempts)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("retries give up after the last attempt", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+
+		for i := 0; i < maxTxAttempts; i++ {
+			mock.ExpectBegin()
+			expectPurge(mock, "1", &mysql.MySQLError{Number: mysqlLockWaitTimeout})
+			mock.ExpectRollback()
+		}
+
+		err := rep.WithinTx(ctx, func(repo TodoListManipulation) error {
+			return repo.PurgeTrash(ctx, before)
+		})
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("serialization failure of postgres is retried", func(t *testing.T) {
+		rep, mock := newMock()
+		defer rep.db.Close()
+
+		mock.ExpectBegin()
+		expectPurge(mock, "1", &pq.Error{Code: postgresSerializationFailure})
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		expectPurge(mock, "1", nil)
+		mock.ExpectCommit()
+
+		err := rep.WithinTx(ctx, func(repo TodoListManipulation) error {
+			return repo.PurgeTrash(ctx, before)
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}